@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/natac13/go-chirpy/internal/backup"
+	"github.com/natac13/go-chirpy/internal/database"
+)
+
+// runCommand runs a chirpy subcommand such as `chirpy migrate --dry-run`
+func runCommand(cfg database.Config, snapshots *backup.Manager, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(cfg, args[1:])
+	case "snapshot":
+		return runSnapshot(cfg, snapshots, args[1:])
+	case "rekey":
+		return runRekey(cfg, snapshots, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runMigrate(cfg database.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Print pending migrations without applying them")
+	flags.Parse(args)
+
+	cfg.SkipMigrations = true
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("Database is up to date")
+		return nil
+	}
+
+	if *dryRun {
+		for _, m := range pending {
+			fmt.Printf("would apply %d: %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		fmt.Printf("applied %d: %s\n", m.Version, m.Name)
+	}
+	return err
+}
+
+func runSnapshot(cfg database.Config, snapshots *backup.Manager, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: chirpy snapshot create|list|restore <name>")
+	}
+
+	switch args[0] {
+	case "list":
+		list, err := snapshots.List()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range list {
+			fmt.Printf("%s\t%d bytes\tsha256:%s\n", s.Name, s.Size, s.Sha256)
+		}
+		return nil
+	case "create":
+		db, err := database.Open(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		snapshot, err := snapshots.Create(db)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created %s\n", snapshot.Name)
+		return nil
+	case "restore":
+		if len(args) != 2 {
+			return errors.New("usage: chirpy snapshot restore <name>")
+		}
+
+		db, err := database.Open(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if err := snapshots.Restore(db, args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("restored %s\n", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown snapshot command %q", args[0])
+	}
+}
+
+// runRekey re-encrypts the database and its snapshots with DATABASE_NEW_ENCRYPTION_KEY,
+// or decrypts them with --decrypt. Stop the server before rotating the key. An
+// unencrypted database is encrypted by running rekey without DATABASE_ENCRYPTION_KEY.
+func runRekey(cfg database.Config, snapshots *backup.Manager, args []string) error {
+	flags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	decrypt := flags.Bool("decrypt", false, "Store the database unencrypted")
+	flags.Parse(args)
+
+	var newKey []byte
+	if !*decrypt {
+		var err error
+		newKey, err = database.ParseEncryptionKey(os.Getenv("DATABASE_NEW_ENCRYPTION_KEY"))
+		if err != nil {
+			return fmt.Errorf("DATABASE_NEW_ENCRYPTION_KEY: %w", err)
+		}
+	}
+
+	if err := database.Rekey(cfg, newKey); err != nil {
+		return err
+	}
+	fmt.Printf("rekeyed %s\n", cfg.Path)
+
+	rewritten, err := snapshots.Rewrite(func(r io.Reader, w io.Writer) error {
+		return database.RekeySnapshot(r, w, cfg.EncryptionKey, newKey)
+	})
+	fmt.Printf("rekeyed %d snapshots\n", rewritten)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("update DATABASE_ENCRYPTION_KEY before starting the server")
+	return nil
+}
+
+// resetDatabase snapshots the database before deleting it, so a
+// --debug run never throws away data that can't be restored
+func resetDatabase(cfg database.Config, snapshots *backup.Manager) error {
+	if _, err := os.Stat(cfg.Path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	cfg.SkipMigrations = true
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := snapshots.Create(db)
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Debug mode enabled. Saved a snapshot before deleting the database.", "snapshot", snapshot.Name)
+
+	for _, suffix := range []string{"", ".journal", "-wal", "-shm"} {
+		if err := os.Remove(cfg.Path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
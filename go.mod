@@ -3,7 +3,8 @@ module github.com/natac13/go-chirpy
 go 1.22.2
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/joho/godotenv v1.5.1
+	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/crypto v0.22.0
 )
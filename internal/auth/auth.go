@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -21,7 +22,7 @@ func ValidateToken(r *http.Request) (int, error) {
 		return 0, errors.New("No authorization header")
 	}
 
-	tokenString := authHeader[len("Bearer "):]
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	claims := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(os.Getenv("JWT_SECRET")), nil
@@ -45,35 +46,50 @@ func ValidateToken(r *http.Request) (int, error) {
 	return strconv.Atoi(userIdString)
 }
 
-func ValidateRefreshToken(authHeader string) (int, string, error) {
-	tokenString := authHeader[len("Bearer "):]
+// RefreshToken is a validated refresh token
+type RefreshToken struct {
+	UserId    int
+	Token     string
+	ExpiresAt time.Time
+}
+
+func ValidateRefreshToken(authHeader string) (RefreshToken, error) {
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	claims := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(os.Getenv("JWT_SECRET")), nil
 	})
 
 	if err != nil {
-		return 0, "", err
+		return RefreshToken{}, err
 	}
 
 	if !token.Valid {
-		return 0, "", errors.New("Invalid token")
+		return RefreshToken{}, errors.New("Invalid token")
 	}
 
 	userIdString := claims.Subject
 	issuer := claims.Issuer
 
 	if issuer != RefreshIssuer {
-		return 0, "", errors.New("Invalid token")
+		return RefreshToken{}, errors.New("Invalid token")
 	}
 
 	// convert string to int
 	userId, err := strconv.Atoi(userIdString)
 	if err != nil {
-		return 0, "", err
+		return RefreshToken{}, err
+	}
+
+	if claims.ExpiresAt == nil {
+		return RefreshToken{}, errors.New("Invalid token")
 	}
 
-	return userId, tokenString, nil
+	return RefreshToken{
+		UserId:    userId,
+		Token:     tokenString,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
 }
 
 func GetAccessToken(userId int) (string, error) {
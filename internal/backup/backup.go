@@ -0,0 +1,318 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/fsutil"
+)
+
+const (
+	filePrefix     = "chirpy-"
+	fileSuffix     = ".gz"
+	checksumSuffix = ".sha256"
+	timeLayout     = "20060102T150405.000Z"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("Snapshot not found")
+	ErrChecksumMismatch = errors.New("Snapshot checksum mismatch")
+)
+
+// Manager stores compressed, checksummed snapshots of a database in a directory
+type Manager struct {
+	dir    string
+	driver string
+	retain int
+}
+
+// Snapshot describes a snapshot on disk
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Driver    string    `json:"driver"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Sha256    string    `json:"sha256"`
+}
+
+// NewManager returns a Manager for snapshots of driver databases kept in dir.
+// Only the newest retain snapshots are kept, 0 keeps all of them.
+func NewManager(dir, driver string, retain int) *Manager {
+	return &Manager{
+		dir:    dir,
+		driver: driver,
+		retain: retain,
+	}
+}
+
+// Create takes a consistent snapshot of db and prunes old snapshots
+func (m *Manager) Create(db database.Store) (Snapshot, error) {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return Snapshot{}, err
+	}
+
+	name := filePrefix + m.driver + "-" + time.Now().UTC().Format(timeLayout) + fileSuffix
+	if err := m.write(name, db.Snapshot); err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := m.prune(); err != nil {
+		return Snapshot{}, err
+	}
+
+	return m.stat(name)
+}
+
+// Rewrite passes the contents of every snapshot of the driver through fn,
+// used to re-encrypt snapshots when the database key is rotated
+func (m *Manager) Rewrite(fn func(r io.Reader, w io.Writer) error) (int, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, snapshot := range snapshots {
+		if snapshot.Driver != m.driver {
+			continue
+		}
+
+		zr, err := m.open(snapshot)
+		if err != nil {
+			return rewritten, fmt.Errorf("%s: %w", snapshot.Name, err)
+		}
+
+		contents, err := io.ReadAll(zr)
+		zr.Close()
+		if err != nil {
+			return rewritten, fmt.Errorf("%s: %w", snapshot.Name, err)
+		}
+
+		err = m.write(snapshot.Name, func(w io.Writer) error {
+			return fn(bytes.NewReader(contents), w)
+		})
+		if err != nil {
+			return rewritten, fmt.Errorf("%s: %w", snapshot.Name, err)
+		}
+		rewritten++
+	}
+
+	return rewritten, nil
+}
+
+// write compresses the output of fill into the named snapshot and its checksum.
+// Both are written to temp files before either is renamed into place,
+// so a failed write never leaves a snapshot next to a stale checksum.
+func (m *Manager) write(name string, fill func(w io.Writer) error) error {
+	path := filepath.Join(m.dir, name)
+
+	tmp, err := os.CreateTemp(m.dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(tmp, hash))
+	if err := fill(zw); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	checksumTmp, err := fsutil.WriteTemp(path+checksumSuffix, []byte(sum+"  "+name+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(checksumTmp)
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if err := os.Rename(checksumTmp, path+checksumSuffix); err != nil {
+		return err
+	}
+
+	return fsutil.SyncDir(m.dir)
+}
+
+// List returns the retained snapshots, newest first
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Snapshot{}, nil
+		}
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+
+		snapshot, err := m.stat(name)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// Restore verifies the named snapshot and replaces the contents of db with it
+func (m *Manager) Restore(db database.Store, name string) error {
+	snapshot, err := m.stat(name)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.Driver != m.driver {
+		return fmt.Errorf("snapshot %s was taken from a %s database, not %s", name, snapshot.Driver, m.driver)
+	}
+
+	zr, err := m.open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	return db.Restore(zr)
+}
+
+// open verifies the checksum of a snapshot and returns its decompressed contents
+func (m *Manager) open(snapshot Snapshot) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(m.dir, snapshot.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != snapshot.Sha256 {
+		f.Close()
+		return nil, ErrChecksumMismatch
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &snapshotReader{Reader: zr, file: f}, nil
+}
+
+// snapshotReader closes the snapshot file along with its gzip reader
+type snapshotReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *snapshotReader) Close() error {
+	return errors.Join(r.Reader.Close(), r.file.Close())
+}
+
+// stat reads the metadata of a snapshot from its name, file and checksum
+func (m *Manager) stat(name string) (Snapshot, error) {
+	if filepath.Base(name) != name || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	driver, stamp, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "-")
+	if !ok {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	createdAt, err := time.Parse(timeLayout, stamp)
+	if err != nil {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	info, err := os.Stat(filepath.Join(m.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Snapshot{}, ErrSnapshotNotFound
+		}
+		return Snapshot{}, err
+	}
+
+	// a missing checksum is reported as empty and fails verification on restore
+	checksum, err := os.ReadFile(filepath.Join(m.dir, name+checksumSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		return Snapshot{}, err
+	}
+
+	sum, _, _ := strings.Cut(string(checksum), " ")
+
+	return Snapshot{
+		Name:      name,
+		Driver:    driver,
+		Size:      info.Size(),
+		CreatedAt: createdAt,
+		Sha256:    sum,
+	}, nil
+}
+
+// prune removes the oldest snapshots beyond the retention count
+func (m *Manager) prune() error {
+	if m.retain <= 0 {
+		return nil
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	for i := m.retain; i < len(snapshots); i++ {
+		path := filepath.Join(m.dir, snapshots[i].Name)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		os.Remove(path + checksumSuffix)
+	}
+
+	return nil
+}
@@ -0,0 +1,389 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var ErrBlocked = errors.New("You are blocked by this user")
+
+// Block records that a user blocks another. The blocked user can't reply
+// to, like or follow the blocker and the blocker no longer sees their chirps.
+type Block struct {
+	BlockerId int       `json:"blocker_id"`
+	BlockedId int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mute records that a user mutes another, hiding their chirps from the muter
+type Mute struct {
+	MuterId   int       `json:"muter_id"`
+	MutedId   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// blockKey is the key a block is stored under in the json database
+func blockKey(blockerId, blockedId int) string {
+	return strconv.Itoa(blockerId) + ":" + strconv.Itoa(blockedId)
+}
+
+// muteKey is the key a mute is stored under in the json database
+func muteKey(muterId, mutedId int) string {
+	return strconv.Itoa(muterId) + ":" + strconv.Itoa(mutedId)
+}
+
+// BlockUser makes blocker block blocked and returns the blocked user.
+// Follows and likes between the two are removed, blocking someone again is a no-op.
+func (db *DB) BlockUser(blockerId, blockedId int) (User, error) {
+	var blocked User
+	err := db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(blockerId); !ok {
+			return errUserNotFound
+		}
+		if _, ok := tx.User(blockedId); !ok {
+			return errUserNotFound
+		}
+
+		if _, ok := tx.Block(blockerId, blockedId); !ok {
+			err := tx.PutBlock(Block{BlockerId: blockerId, BlockedId: blockedId, CreatedAt: time.Now().UTC()})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := setFollow(tx, blockerId, blockedId, false); err != nil {
+			return err
+		}
+		if err := setFollow(tx, blockedId, blockerId, false); err != nil {
+			return err
+		}
+
+		if err := removeLikes(tx, blockerId, blockedId); err != nil {
+			return err
+		}
+		if err := removeLikes(tx, blockedId, blockerId); err != nil {
+			return err
+		}
+
+		blocked, _ = tx.User(blockedId)
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return blocked, nil
+}
+
+// removeLikes removes userId's likes of the chirps by authorId
+func removeLikes(tx *Tx, authorId, userId int) error {
+	for _, chirpId := range slices.Clone(tx.LikesBy(userId)) {
+		chirp, ok := tx.Chirp(chirpId)
+		if !ok || chirp.AuthorId != authorId {
+			continue
+		}
+
+		if err := tx.DeleteLike(chirpId, userId); err != nil {
+			return err
+		}
+
+		chirp.LikeCount--
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnblockUser stops blocker blocking blocked and returns the blocked user
+func (db *DB) UnblockUser(blockerId, blockedId int) (User, error) {
+	var blocked User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		blocked, ok = tx.User(blockedId)
+		if !ok {
+			return errUserNotFound
+		}
+
+		if _, ok := tx.Block(blockerId, blockedId); !ok {
+			return nil
+		}
+		return tx.DeleteBlock(blockerId, blockedId)
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return blocked, nil
+}
+
+// MuteUser makes muter mute muted and returns the muted user,
+// muting someone again is a no-op
+func (db *DB) MuteUser(muterId, mutedId int) (User, error) {
+	var muted User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		muted, ok = tx.User(mutedId)
+		if !ok {
+			return errUserNotFound
+		}
+
+		if _, ok := tx.Mute(muterId, mutedId); ok {
+			return nil
+		}
+		return tx.PutMute(Mute{MuterId: muterId, MutedId: mutedId, CreatedAt: time.Now().UTC()})
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return muted, nil
+}
+
+// UnmuteUser stops muter muting muted and returns the muted user
+func (db *DB) UnmuteUser(muterId, mutedId int) (User, error) {
+	var muted User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		muted, ok = tx.User(mutedId)
+		if !ok {
+			return errUserNotFound
+		}
+
+		if _, ok := tx.Mute(muterId, mutedId); !ok {
+			return nil
+		}
+		return tx.DeleteMute(muterId, mutedId)
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return muted, nil
+}
+
+// GetBlockedUsers returns the users a user blocks, most recently blocked first
+func (db *DB) GetBlockedUsers(userId int) ([]User, error) {
+	users := []User{}
+	err := db.View(func(tx *Tx) error {
+		blocks := []Block{}
+		for _, blockedId := range tx.Blocked(userId) {
+			block, _ := tx.Block(userId, blockedId)
+			blocks = append(blocks, block)
+		}
+
+		slices.SortFunc(blocks, func(a, b Block) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.BlockedId, a.BlockedId)
+		})
+
+		for _, block := range blocks {
+			user, _ := tx.User(block.BlockedId)
+			users = append(users, user)
+		}
+		return nil
+	})
+
+	return users, err
+}
+
+// GetMutedUsers returns the users a user mutes, most recently muted first
+func (db *DB) GetMutedUsers(userId int) ([]User, error) {
+	users := []User{}
+	err := db.View(func(tx *Tx) error {
+		mutes := []Mute{}
+		for _, mutedId := range tx.Muted(userId) {
+			mute, _ := tx.Mute(userId, mutedId)
+			mutes = append(mutes, mute)
+		}
+
+		slices.SortFunc(mutes, func(a, b Mute) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.MutedId, a.MutedId)
+		})
+
+		for _, mute := range mutes {
+			user, _ := tx.User(mute.MutedId)
+			users = append(users, user)
+		}
+		return nil
+	})
+
+	return users, err
+}
+
+// checkBlocked returns ErrBlocked if blocker blocks userId
+func checkBlocked(tx *Tx, blockerId, userId int) error {
+	if _, ok := tx.Block(blockerId, userId); ok {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// silencedBy returns the ids of the users whose chirps are hidden from a
+// user because they block or mute them, nil for userId 0
+func silencedBy(tx *Tx, userId int) map[int]bool {
+	if userId == 0 {
+		return nil
+	}
+
+	silenced := map[int]bool{}
+	for _, id := range tx.Blocked(userId) {
+		silenced[id] = true
+	}
+	for _, id := range tx.Muted(userId) {
+		silenced[id] = true
+	}
+	return silenced
+}
+
+// BlockUser makes blocker block blocked and returns the blocked user.
+// Follows and likes between the two are removed, blocking someone again is a no-op.
+func (db *SQLiteDB) BlockUser(blockerId, blockedId int) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	var users int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE id IN (?, ?)`, blockerId, blockedId).Scan(&users)
+	if err != nil {
+		return User{}, err
+	}
+	if users != 2 {
+		return User{}, errUserNotFound
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING`,
+		blockerId, blockedId, time.Now().UTC()); err != nil {
+		return User{}, err
+	}
+
+	for _, pair := range [][2]int{{blockerId, blockedId}, {blockedId, blockerId}} {
+		res, err := tx.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, pair[0], pair[1])
+		if err != nil {
+			return User{}, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return User{}, err
+		}
+		if _, err := tx.Exec(`UPDATE users SET following_count = following_count - ? WHERE id = ?`, n, pair[0]); err != nil {
+			return User{}, err
+		}
+		if _, err := tx.Exec(`UPDATE users SET follower_count = follower_count - ? WHERE id = ?`, n, pair[1]); err != nil {
+			return User{}, err
+		}
+
+		// pair[0] no longer likes the chirps of pair[1]
+		if _, err := tx.Exec(`
+			UPDATE chirps SET like_count = like_count - 1
+			WHERE author_id = ? AND id IN (SELECT chirp_id FROM chirp_likes WHERE user_id = ?)`,
+			pair[1], pair[0]); err != nil {
+			return User{}, err
+		}
+		if _, err := tx.Exec(`
+			DELETE FROM chirp_likes
+			WHERE user_id = ? AND chirp_id IN (SELECT id FROM chirps WHERE author_id = ?)`,
+			pair[0], pair[1]); err != nil {
+			return User{}, err
+		}
+	}
+
+	blocked, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, blockedId))
+	if err != nil {
+		return User{}, err
+	}
+
+	return blocked, tx.Commit()
+}
+
+// UnblockUser stops blocker blocking blocked and returns the blocked user
+func (db *SQLiteDB) UnblockUser(blockerId, blockedId int) (User, error) {
+	return db.updateUserPair(blockedId, `DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?`, blockerId, blockedId)
+}
+
+// MuteUser makes muter mute muted and returns the muted user,
+// muting someone again is a no-op
+func (db *SQLiteDB) MuteUser(muterId, mutedId int) (User, error) {
+	return db.updateUserPair(mutedId, `
+		INSERT INTO mutes (muter_id, muted_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (muter_id, muted_id) DO NOTHING`,
+		muterId, mutedId, time.Now().UTC())
+}
+
+// UnmuteUser stops muter muting muted and returns the muted user
+func (db *SQLiteDB) UnmuteUser(muterId, mutedId int) (User, error) {
+	return db.updateUserPair(mutedId, `DELETE FROM mutes WHERE muter_id = ? AND muted_id = ?`, muterId, mutedId)
+}
+
+// updateUserPair runs a statement about a user and another one and returns the other user
+func (db *SQLiteDB) updateUserPair(otherId int, query string, args ...any) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	other, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, otherId))
+	if err != nil {
+		return User{}, err
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return User{}, err
+	}
+
+	return other, tx.Commit()
+}
+
+// GetBlockedUsers returns the users a user blocks, most recently blocked first
+func (db *SQLiteDB) GetBlockedUsers(userId int) ([]User, error) {
+	return db.queryUsers(`
+		SELECT `+qualifiedUserColumns+` FROM blocks
+		JOIN users ON users.id = blocks.blocked_id
+		WHERE blocks.blocker_id = ?
+		ORDER BY blocks.created_at DESC, blocks.blocked_id DESC`, userId)
+}
+
+// GetMutedUsers returns the users a user mutes, most recently muted first
+func (db *SQLiteDB) GetMutedUsers(userId int) ([]User, error) {
+	return db.queryUsers(`
+		SELECT `+qualifiedUserColumns+` FROM mutes
+		JOIN users ON users.id = mutes.muted_id
+		WHERE mutes.muter_id = ?
+		ORDER BY mutes.created_at DESC, mutes.muted_id DESC`, userId)
+}
+
+// checkSQLiteBlocked returns ErrBlocked if blocker blocks userId
+func checkSQLiteBlocked(tx *sql.Tx, blockerId, userId int) error {
+	var blocked bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = ? AND blocked_id = ?)`, blockerId, userId).
+		Scan(&blocked)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// silencedClause leaves out the chirps of the users a user blocks or mutes,
+// it takes the user id three times
+const silencedClause = `(? = 0 OR author_id NOT IN (
+	SELECT blocked_id FROM blocks WHERE blocker_id = ?
+	UNION SELECT muted_id FROM mutes WHERE muter_id = ?
+))`
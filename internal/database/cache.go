@@ -0,0 +1,87 @@
+package database
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// fileStamp identifies the on disk state the in memory cache was built from
+type fileStamp struct {
+	snapshotSize    int64
+	snapshotModTime time.Time
+	journalSize     int64
+	journalModTime  time.Time
+}
+
+func (db *DB) currentStamp() (fileStamp, error) {
+	var stamp fileStamp
+
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return stamp, err
+	}
+	stamp.snapshotSize = info.Size()
+	stamp.snapshotModTime = info.ModTime()
+
+	info, err = os.Stat(db.journalPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stamp, err
+	}
+	if err == nil {
+		stamp.journalSize = info.Size()
+		stamp.journalModTime = info.ModTime()
+	}
+
+	return stamp, nil
+}
+
+// refreshStamp records our own writes so they aren't mistaken for external ones.
+// The caller must hold the lock.
+func (db *DB) refreshStamp() error {
+	stamp, err := db.currentStamp()
+	if err != nil {
+		return err
+	}
+
+	db.stamp = stamp
+	return nil
+}
+
+// reloadIfChanged reloads the cache when the database files were
+// modified by something other than this process
+func (db *DB) reloadIfChanged() error {
+	stamp, err := db.currentStamp()
+	if err != nil {
+		return err
+	}
+
+	db.mux.RLock()
+	changed := stamp != db.stamp
+	db.mux.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.reloadIfChangedLocked()
+}
+
+// reloadIfChangedLocked is reloadIfChanged for callers already holding the lock
+func (db *DB) reloadIfChangedLocked() error {
+	stamp, err := db.currentStamp()
+	if err != nil {
+		return err
+	}
+
+	if stamp == db.stamp {
+		return nil
+	}
+
+	slog.Info("DATABASE - Database file changed on disk, reloading", "path", db.path)
+	return db.loadDB()
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/natac13/go-chirpy/internal/fsutil"
+)
+
+func TestReloadIfChanged(t *testing.T) {
+	tests := []struct {
+		name string
+		// change writes to the database through another handle on the same files
+		change     func(t *testing.T, other *DB)
+		wantChirps []int
+	}{
+		{
+			name:       "unchanged",
+			change:     func(t *testing.T, other *DB) {},
+			wantChirps: []int{1},
+		},
+		{
+			name: "journal appended",
+			change: func(t *testing.T, other *DB) {
+				err := other.Update(func(tx *Tx) error {
+					return tx.PutChirp(Chirp{Id: 2, AuthorId: 1, Body: "appended"})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantChirps: []int{1, 2},
+		},
+		{
+			name: "journal compacted",
+			change: func(t *testing.T, other *DB) {
+				for i := 2; i <= compactEvery+1; i++ {
+					err := other.Update(func(tx *Tx) error {
+						return tx.PutChirp(Chirp{Id: i, AuthorId: 1, Body: "compacted"})
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			wantChirps: func() []int {
+				ids := []int{}
+				for i := 1; i <= compactEvery+1; i++ {
+					ids = append(ids, i)
+				}
+				return ids
+			}(),
+		},
+		{
+			name: "snapshot replaced",
+			change: func(t *testing.T, other *DB) {
+				data := newTestData()
+				data.Chirps[5] = Chirp{Id: 5, AuthorId: 1, Body: "restored"}
+
+				file, err := json.Marshal(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := fsutil.WriteFileAtomic(other.path, file, 0666); err != nil {
+					t.Fatal(err)
+				}
+				if err := other.truncateJournal(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantChirps: []int{5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, nil)
+			err := db.Update(func(tx *Tx) error {
+				return tx.PutChirp(Chirp{Id: 1, AuthorId: 1, Body: "first"})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			other, err := NewDB(db.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, other)
+
+			var got []int
+			err = db.View(func(tx *Tx) error {
+				got = sortedKeys(tx.data.Chirps)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.wantChirps) {
+				t.Errorf("chirps = %v, want %v", got, tt.wantChirps)
+			}
+
+			if got := db.data.idx.chirpIds; !slices.Equal(got, tt.wantChirps) {
+				t.Errorf("chirpIds index = %v, want %v", got, tt.wantChirps)
+			}
+		})
+	}
+}
+
+func TestOwnWritesDontReload(t *testing.T) {
+	db := newTestDB(t, nil)
+	err := db.Update(func(tx *Tx) error {
+		return tx.PutChirp(Chirp{Id: 1, AuthorId: 1, Body: "first"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a reload would replace the in memory data and drop this marker
+	db.data.Chirps[99] = Chirp{Id: 99}
+	if err := db.reloadIfChanged(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := db.data.Chirps[99]; !ok {
+		t.Error("database reloaded after its own write")
+	}
+}
+
+func TestUpdateSeesExternalWrites(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	other, err := NewDB(db.path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Update(func(tx *Tx) error {
+		return tx.PutChirp(Chirp{Id: 1, AuthorId: 1, Body: "external"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen bool
+	err = db.Update(func(tx *Tx) error {
+		_, seen = tx.Chirp(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !seen {
+		t.Error("Update didn't see a chirp written by another handle")
+	}
+}
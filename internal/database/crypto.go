@@ -0,0 +1,148 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("database: the database file is encrypted but no encryption key is configured")
+	ErrWrongEncryptionKey    = errors.New("database: cannot decrypt the database file, the encryption key is wrong")
+	ErrNotEncrypted          = errors.New("database: the database file is not encrypted, encrypt it with the rekey command")
+)
+
+// encryptedMagic prefixes encrypted snapshots, encryptedLinePrefix encrypted journal lines
+var (
+	encryptedMagic      = []byte("CHIRPYENC1")
+	encryptedLinePrefix = []byte("enc:")
+)
+
+// ParseEncryptionKey decodes a base64 encoded AES-128, 192 or 256 key
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("database: encryption key is not valid base64: %w", err)
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("database: encryption key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+}
+
+// newAEAD returns the AES-GCM cipher for key, or nil when encryption is disabled
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext as magic | nonce | ciphertext,
+// a nil aead leaves it untouched
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	if aead == nil {
+		return plaintext, nil
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, encryptedMagic), nil
+}
+
+// unseal reverses seal. With a key plaintext data is rejected, as anyone able
+// to write the files could otherwise slip in records that were never authenticated.
+// An existing database is encrypted by rekeying it from no key.
+func unseal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	encrypted := bytes.HasPrefix(data, encryptedMagic)
+	if aead == nil {
+		if encrypted {
+			return nil, ErrEncryptionKeyRequired
+		}
+		return data, nil
+	}
+	if !encrypted {
+		return nil, ErrNotEncrypted
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrWrongEncryptionKey
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, encryptedMagic)
+	if err != nil {
+		return nil, ErrWrongEncryptionKey
+	}
+
+	return plaintext, nil
+}
+
+// sealLine encrypts a journal line, keeping it free of newlines
+func sealLine(aead cipher.AEAD, line []byte) ([]byte, error) {
+	if aead == nil {
+		return line, nil
+	}
+
+	sealed, err := seal(aead, line)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedLinePrefix...)
+	return base64.StdEncoding.AppendEncode(out, sealed), nil
+}
+
+// unsealLine reverses sealLine, rejecting plaintext lines like unseal
+func unsealLine(aead cipher.AEAD, line []byte) ([]byte, error) {
+	if !bytes.HasPrefix(line, encryptedLinePrefix) {
+		return unseal(aead, line)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(line[len(encryptedLinePrefix):]))
+	if err != nil {
+		return nil, err
+	}
+
+	return unseal(aead, sealed)
+}
+
+// rekey writes a new snapshot encrypted with newKey. The journal
+// is already part of the in memory data and is truncated.
+func (db *DB) rekey(newKey []byte) error {
+	aead, err := newAEAD(newKey)
+	if err != nil {
+		return err
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	previous := db.aead
+	db.aead = aead
+	if err := db.writeDB(); err != nil {
+		db.aead = previous
+		return err
+	}
+
+	return nil
+}
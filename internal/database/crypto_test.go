@@ -0,0 +1,222 @@
+package database
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func testKey(b byte, size int) []byte {
+	return bytes.Repeat([]byte{b}, size)
+}
+
+func testAEAD(t *testing.T, key []byte) cipher.AEAD {
+	t.Helper()
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantLen int
+		wantErr bool
+	}{
+		{"aes-128", base64.StdEncoding.EncodeToString(testKey(1, 16)), 16, false},
+		{"aes-192", base64.StdEncoding.EncodeToString(testKey(1, 24)), 24, false},
+		{"aes-256", base64.StdEncoding.EncodeToString(testKey(1, 32)), 32, false},
+		{"wrong size", base64.StdEncoding.EncodeToString(testKey(1, 20)), 0, true},
+		{"not base64", "not a key!", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseEncryptionKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEncryptionKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(key) != tt.wantLen {
+				t.Errorf("key is %d bytes, want %d", len(key), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"chirps":{}}`)
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"no key", nil},
+		{"aes-128", testKey(1, 16)},
+		{"aes-256", testKey(1, 32)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aead := testAEAD(t, tt.key)
+
+			sealed, err := seal(aead, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aead != nil && bytes.Contains(sealed, plaintext) {
+				t.Error("sealed data contains the plaintext")
+			}
+
+			got, err := unseal(aead, sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("unseal = %q, want %q", got, plaintext)
+			}
+
+			line, err := sealLine(aead, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.ContainsRune(line, '\n') {
+				t.Error("sealed line contains a newline")
+			}
+
+			got, err = unsealLine(aead, line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("unsealLine = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestUnsealRejects(t *testing.T) {
+	key := testKey(1, 32)
+	plaintext := []byte(`{"chirps":{}}`)
+
+	sealed, err := seal(testAEAD(t, key), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tamperedNonce := bytes.Clone(sealed)
+	tamperedNonce[len(encryptedMagic)] ^= 1
+
+	tests := []struct {
+		name    string
+		key     []byte
+		data    []byte
+		wantErr error
+	}{
+		{"encrypted without a key", nil, sealed, ErrEncryptionKeyRequired},
+		{"plaintext with a key", key, plaintext, ErrNotEncrypted},
+		{"wrong key", testKey(2, 32), sealed, ErrWrongEncryptionKey},
+		{"tampered ciphertext", key, tampered, ErrWrongEncryptionKey},
+		{"tampered nonce", key, tamperedNonce, ErrWrongEncryptionKey},
+		{"truncated", key, sealed[:len(encryptedMagic)+4], ErrWrongEncryptionKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aead := testAEAD(t, tt.key)
+
+			if _, err := unseal(aead, tt.data); !errors.Is(err, tt.wantErr) {
+				t.Errorf("unseal error = %v, want %v", err, tt.wantErr)
+			}
+
+			line := tt.data
+			if bytes.HasPrefix(tt.data, encryptedMagic) {
+				line = base64.StdEncoding.AppendEncode(bytes.Clone(encryptedLinePrefix), tt.data)
+			}
+			if _, err := unsealLine(aead, line); !errors.Is(err, tt.wantErr) {
+				t.Errorf("unsealLine error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptedDB(t *testing.T) {
+	key := testKey(1, 32)
+
+	db := newTestDB(t, nil)
+	err := db.Update(func(tx *Tx) error {
+		return tx.PutChirp(Chirp{Id: 1, AuthorId: 1, Body: "before rekey"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an unencrypted database is encrypted by rekeying it from no key
+	if err := db.rekey(key); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		return tx.PutChirp(Chirp{Id: 2, AuthorId: 1, Body: "after rekey"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		key        []byte
+		wantErr    error
+		wantChirps []int
+	}{
+		{"right key", key, nil, []int{1, 2}},
+		{"no key", nil, ErrEncryptionKeyRequired, nil},
+		{"wrong key", testKey(2, 32), ErrWrongEncryptionKey, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reopened, err := openDB(db.path, tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("openDB error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := sortedKeys(reopened.data.Chirps); !slices.Equal(got, tt.wantChirps) {
+				t.Errorf("chirps = %v, want %v", got, tt.wantChirps)
+			}
+		})
+	}
+}
+
+func TestPlaintextDBWithKey(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	if _, err := openDB(db.path, testKey(1, 32)); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("openDB error = %v, want %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestPlaintextJournalLineWithKey(t *testing.T) {
+	db := newTestDB(t, testKey(1, 32))
+
+	// a plaintext entry slipped into the journal of an encrypted database
+	plain := &DB{path: db.path}
+	if err := plain.appendJournal([]journalOp{putChirpOp(t, 1, "forged")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openDB(db.path, testKey(1, 32)); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("openDB error = %v, want %v", err, ErrNotEncrypted)
+	}
+}
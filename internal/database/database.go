@@ -1,50 +1,197 @@
 package database
 
 import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log/slog"
 	"os"
-	"sort"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/natac13/go-chirpy/internal/fsutil"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
+var _ Store = (*DB)(nil)
+
+var (
+	errUserNotFound  = errors.New("User not found")
+	errChirpNotFound = errors.New("Chirp not found")
+
+	ErrUsernameTaken = errors.New("Username is already taken")
+	ErrEmailTaken    = errors.New("Email is already taken")
+)
+
 type DB struct {
-	path string
-	mux  sync.RWMutex
+	path           string
+	aead           cipher.AEAD
+	mux            sync.RWMutex
+	data           DBStructure
+	stamp          fileStamp
+	journalEntries int
 }
 
 type Chirp struct {
-	AuthorId int    `json:"author_id"`
-	Body     string `json:"body"`
-	Id       int    `json:"id"`
+	AuthorId  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	Id        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Edited is set once the body has been changed, EditedAt is the time of the last edit
+	Edited   bool       `json:"edited"`
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// InReplyToId is the chirp this one replies to and ReplyCount
+	// the number of direct replies to this one that aren't deleted
+	InReplyToId int `json:"in_reply_to_id,omitempty"`
+	ReplyCount  int `json:"reply_count"`
+	// Deleted marks a tombstone, a deleted chirp kept with an empty
+	// body so its replies stay part of the conversation
+	Deleted bool `json:"deleted,omitempty"`
+	// DeletedAt is set while the chirp is in its author's trash, where
+	// it can be restored until it is purged, see DeleteChirp
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// LikeCount is the number of users who like the chirp. LikedByMe is
+	// filled in by the handlers for the requesting user and never stored.
+	LikeCount int  `json:"like_count"`
+	LikedByMe bool `json:"liked_by_me"`
+	// RechirpOfId is the chirp a plain rechirp re-shares, QuoteOfId the chirp
+	// a quote comments on in its body. Referenced is that chirp, filled in
+	// by the handlers and left nil once it has been deleted.
+	RechirpOfId int    `json:"rechirp_of_id,omitempty"`
+	QuoteOfId   int    `json:"quote_of_id,omitempty"`
+	Referenced  *Chirp `json:"referenced_chirp,omitempty"`
+	// Entities are the hashtags and mentions in the body
+	Entities ChirpEntities `json:"entities"`
+	// Hidden is set by a moderator, a hidden chirp is only shown to its author
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// Removed reports whether a chirp is a tombstone or in the trash,
+// either way it isn't shown
+func (chirp Chirp) Removed() bool {
+	return chirp.Deleted || chirp.DeletedAt != nil
+}
+
+// ChirpRevision is a previous body of an edited chirp. Versions count up from 1,
+// CreatedAt is when that body was written.
+type ChirpRevision struct {
+	ChirpId   int       `json:"chirp_id"`
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type User struct {
-	Email       string `json:"email"`
-	Id          int    `json:"id"`
-	Password    string `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Email       string    `json:"email"`
+	Id          int       `json:"id"`
+	Password    string    `json:"password"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// FollowerCount is the number of users following this one,
+	// FollowingCount the number of users this one follows
+	FollowerCount  int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+	// Username is what @mentions refer to, optional and unique ignoring case
+	Username string `json:"username,omitempty"`
+	// Flagged is set while enough users have open reports about this one
+	Flagged bool `json:"flagged,omitempty"`
 }
 
+// RevokedToken is stored under the sha256 of the token, see hashToken
 type RevokedToken struct {
 	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// DBStructure is the whole json database. Version is the last migration
+// applied to the file and Sequences the last id handed out per table.
 type DBStructure struct {
+	Version       int                     `json:"version"`
 	Chirps        map[int]Chirp           `json:"chirps"`
 	Users         map[int]User            `json:"users"`
 	RevokedTokens map[string]RevokedToken `json:"revoked_tokens"`
+	Sequences     map[string]int          `json:"sequences"`
+	// ChirpRevisions holds the revisions of each edited chirp, oldest first
+	ChirpRevisions map[int][]ChirpRevision `json:"chirp_revisions"`
+	// Likes is keyed by likeKey and Follows by followKey
+	Likes   map[string]Like   `json:"likes"`
+	Follows map[string]Follow `json:"follows"`
+	// Moderation is the moderation queue keyed by chirp id
+	Moderation map[int]ModerationItem `json:"moderation"`
+	Reports    map[int]Report         `json:"reports"`
+	// Blocks is keyed by blockKey and Mutes by muteKey
+	Blocks map[string]Block `json:"blocks"`
+	Mutes  map[string]Mute  `json:"mutes"`
+	// Scheduled holds the chirps waiting to be published
+	Scheduled map[int]ScheduledChirp `json:"scheduled_chirps"`
+
+	idx *indexes
+}
+
+// hashToken is the key a revoked token is stored under,
+// so raw refresh tokens never sit on disk
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newDBStructure() DBStructure {
+	return DBStructure{
+		Chirps:        map[int]Chirp{},
+		Users:         map[int]User{},
+		RevokedTokens: map[string]RevokedToken{},
+		Sequences:     map[string]int{},
+
+		ChirpRevisions: map[int][]ChirpRevision{},
+		Likes:          map[string]Like{},
+		Follows:        map[string]Follow{},
+		Moderation:     map[int]ModerationItem{},
+		Reports:        map[int]Report{},
+		Blocks:         map[string]Block{},
+		Mutes:          map[string]Mute{},
+		Scheduled:      map[int]ScheduledChirp{},
+	}
 }
 
 // NewDB creates a new database connection
-// and creates the database file if it doesn't exist
-func NewDB(path string) (*DB, error) {
+// and creates the database file if it doesn't exist.
+// Any journal left behind by a previous run is replayed on top
+// of the snapshot, then pending migrations are run.
+// With an encryption key the files are encrypted with AES-GCM.
+func NewDB(path string, encryptionKey []byte) (*DB, error) {
+	db, err := openDB(path, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range applied {
+		slog.Info("DATABASE - Applied migration", "version", m.Version, "name", m.Name)
+	}
+
+	return db, nil
+}
+
+// openDB is NewDB without running migrations
+func openDB(path string, encryptionKey []byte) (*DB, error) {
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
 	db := &DB{
 		path: path,
+		aead: aead,
 		mux:  sync.RWMutex{},
 	}
 
@@ -52,16 +199,39 @@ func NewDB(path string) (*DB, error) {
 		return nil, err
 	}
 
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if err := db.loadDB(); err != nil {
+		return nil, err
+	}
+
+	// the files are only rewritten once Migrate changes the data, so
+	// opening the database for read only commands leaves them untouched
+	if err := checkVersion(db.data.Version, latestJSONVersion()); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// Close is a no-op for the json database, every write is already on disk
+func (db *DB) Close() error {
+	return nil
+}
+
 // ensureDB creates a new database file if it doesn't exist
 func (db *DB) ensureDB() error {
 	// create the database file if it doesn't exist
 	_, err := os.ReadFile(db.path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if err := os.WriteFile(db.path, []byte("{}"), 0666); err != nil {
+			data, err := seal(db.aead, []byte("{}"))
+			if err != nil {
+				return err
+			}
+
+			if err := fsutil.WriteFileAtomic(db.path, data, 0666); err != nil {
 				return err
 			}
 		} else {
@@ -71,258 +241,584 @@ func (db *DB) ensureDB() error {
 	return nil
 }
 
-// loadDB reads the database file into memory
-func (db *DB) loadDB() (DBStructure, error) {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-
-	data := DBStructure{
-		Chirps:        map[int]Chirp{},
-		Users:         map[int]User{},
-		RevokedTokens: map[string]RevokedToken{},
+// loadDB reads the snapshot into memory and replays the journal on top of it.
+// The caller must hold the lock.
+func (db *DB) loadDB() error {
+	stamp, err := db.currentStamp()
+	if err != nil {
+		return err
 	}
 
+	data := newDBStructure()
+
 	file, err := os.ReadFile(db.path)
 	if err != nil {
-		return data, err
+		return err
+	}
+
+	file, err = unseal(db.aead, file)
+	if err != nil {
+		return err
 	}
 
 	if err := json.Unmarshal(file, &data); err != nil {
-		return data, err
+		return err
 	}
 
-	return data, nil
+	entries, err := db.replayJournal(&data)
+	if err != nil {
+		return err
+	}
+
+	data.buildIndexes()
+
+	db.data = data
+	db.stamp = stamp
+	db.journalEntries = entries
+	return nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+// commit appends the ops of a successful transaction to the journal as one entry,
+// compacting the journal into a snapshot every compactEvery entries.
+// The caller must hold the lock.
+func (db *DB) commit(tx *Tx) error {
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	if err := db.appendJournal(tx.ops); err != nil {
+		return err
+	}
+	db.journalEntries++
+
+	if db.journalEntries >= compactEvery {
+		// the entry is already durable in the journal, a failed
+		// compaction is retried on the next commit
+		if err := db.writeDB(); err != nil {
+			slog.Error("DATABASE - Error compacting journal", "error", err)
+		}
+	}
+
+	if err := db.refreshStamp(); err != nil {
+		slog.Error("DATABASE - Error reading database file stamp", "error", err)
+	}
 
-	data, err := json.Marshal(dbStructure)
+	return nil
+}
+
+// writeDB atomically replaces the snapshot on disk with the in memory
+// structure and truncates the journal. The caller must hold the lock.
+func (db *DB) writeDB() error {
+	data, err := json.Marshal(db.data)
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(db.path, data, 0666); err != nil {
+	data, err = seal(db.aead, data)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	if err := fsutil.WriteFileAtomic(db.path, data, 0666); err != nil {
+		return err
+	}
+
+	if err := db.truncateJournal(); err != nil {
+		return err
+	}
+	db.journalEntries = 0
+
+	return db.refreshStamp()
+}
+
+// CreateChirp creates a new chirp and saves it to disk,
+// counting it as a reply on the chirp it replies to. A user can't
+// reply to someone who blocks them. Rechirping a chirp the author already rechirped returns the existing rechirp.
+func (db *DB) CreateChirp(newChirp NewChirp) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = createChirp(tx, newChirp)
+		return err
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, userId int) (Chirp, error) {
+// createChirp creates a chirp within tx, see CreateChirp
+func createChirp(tx *Tx, newChirp NewChirp) (Chirp, error) {
+	if newChirp.RechirpOfId != 0 {
+		original, err := referencedChirp(tx, newChirp.RechirpOfId)
+		if err != nil {
+			return Chirp{}, err
+		}
+		newChirp.RechirpOfId = original.Id
 
-	data, err := db.loadDB()
+		// a user rechirps a chirp at most once, one in their trash is replaced
+		for _, id := range slices.Clone(tx.Rechirps(original.Id)) {
+			existing, _ := tx.Chirp(id)
+			if existing.AuthorId != newChirp.AuthorId {
+				continue
+			}
+			if existing.DeletedAt == nil {
+				return existing, nil
+			}
+			if err := deleteChirp(tx, id); err != nil {
+				return Chirp{}, err
+			}
+		}
+	}
+
+	if newChirp.QuoteOfId != 0 {
+		original, err := referencedChirp(tx, newChirp.QuoteOfId)
+		if err != nil {
+			return Chirp{}, err
+		}
+		newChirp.QuoteOfId = original.Id
+	}
+
+	if newChirp.InReplyToId != 0 {
+		parent, ok := tx.Chirp(newChirp.InReplyToId)
+		if !ok || parent.Removed() {
+			return Chirp{}, errChirpNotFound
+		}
+
+		if err := checkBlocked(tx, parent.AuthorId, newChirp.AuthorId); err != nil {
+			return Chirp{}, err
+		}
+
+		parent.ReplyCount++
+		if err := tx.PutChirp(parent); err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	entities, err := chirpEntities(newChirp.Body, tx.userByUsername)
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	chirp := Chirp{
-		Id:       len(data.Chirps) + 1,
-		Body:     body,
-		AuthorId: userId,
+	id, err := tx.nextId(tableChirps)
+	if err != nil {
+		return Chirp{}, err
 	}
 
-	data.Chirps[chirp.Id] = chirp
+	now := time.Now().UTC()
+	chirp := Chirp{
+		Id:          id,
+		Body:        newChirp.Body,
+		Entities:    entities,
+		AuthorId:    newChirp.AuthorId,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		InReplyToId: newChirp.InReplyToId,
+		RechirpOfId: newChirp.RechirpOfId,
+		QuoteOfId:   newChirp.QuoteOfId,
+	}
+
+	if err := tx.PutChirp(chirp); err != nil {
+		return Chirp{}, err
+	}
 
-	if err := db.writeDB(data); err != nil {
-		return chirp, err
+	if len(newChirp.FlagReasons) > 0 {
+		if _, err := flagChirp(tx, chirp.Id, newChirp.FlagReasons); err != nil {
+			return Chirp{}, err
+		}
 	}
 
 	return chirp, nil
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps(authorId int, sorting string) ([]Chirp, error) {
+// GetChirps returns a page of chirps walking the id indexes in sort order,
+// so only the chirps on the page and those outside the time range are read
+func (db *DB) GetChirps(query ChirpQuery) (ChirpPage, error) {
+	page := ChirpPage{Chirps: []Chirp{}}
+	err := db.View(func(tx *Tx) error {
+		// walk the index of one filter, page.add checks the others
+		ids := tx.data.idx.chirpIds
+		switch {
+		case query.Hashtag != "":
+			ids = tx.data.idx.chirpsByHashtag[query.Hashtag]
+		case query.MentionedId != 0:
+			ids = tx.data.idx.chirpsMentioning[query.MentionedId]
+		case query.AuthorId != 0:
+			ids = tx.data.idx.chirpsByAuthor[query.AuthorId]
+		}
 
-	data, err := db.loadDB()
+		query.silenced = silencedBy(tx, query.MutedBy)
+		walkIds(ids, query.After, query.Sort == "desc", func(id int) bool {
+			return page.add(query, tx.data.Chirps[id])
+		})
+		return nil
+	})
 	if err != nil {
 		slog.Error("DATABASE - Error getting chirps", "error", err)
-		return nil, err
+		return ChirpPage{}, err
 	}
 
-	if len(data.Chirps) == 0 {
-		return []Chirp{}, nil
+	return page, nil
+}
+
+// referencedChirp returns the chirp a new rechirp or quote refers to,
+// following a plain rechirp to the chirp it re-shares
+func referencedChirp(tx *Tx, chirpId int) (Chirp, error) {
+	chirp, ok := tx.Chirp(chirpId)
+	if !ok || chirp.Removed() {
+		return Chirp{}, errChirpNotFound
 	}
 
-	chirps := []Chirp{}
-	for _, chirp := range data.Chirps {
-		if authorId != 0 && chirp.AuthorId != authorId {
-			continue
-		}
-		chirps = append(chirps, chirp)
+	if chirp.RechirpOfId != 0 {
+		chirp, _ = tx.Chirp(chirp.RechirpOfId)
 	}
 
-	sort.Slice(chirps, func(i, y int) bool {
-		if sorting == "desc" {
-			return chirps[i].Id > chirps[y].Id
+	return chirp, nil
+}
+
+// GetChirpsByIds returns the chirps with the given ids that exist, keyed by id
+func (db *DB) GetChirpsByIds(chirpIds []int) (map[int]Chirp, error) {
+	chirps := map[int]Chirp{}
+	err := db.View(func(tx *Tx) error {
+		for _, id := range chirpIds {
+			if chirp, ok := tx.Chirp(id); ok {
+				chirps[id] = chirp
+			}
 		}
-		return chirps[i].Id < chirps[y].Id
+		return nil
 	})
 
-	return chirps, nil
+	return chirps, err
 }
 
 func (db *DB) GetChirpById(chirpId int) (Chirp, error) {
-	data, err := db.loadDB()
+	var chirp Chirp
+	err := db.View(func(tx *Tx) error {
+		chirp, _ = tx.Chirp(chirpId)
+		return nil
+	})
+
+	return chirp, err
+}
+
+// UpdateChirp replaces the body of a chirp and its entities,
+// keeping the previous body as a revision. Flag reasons put it
+// back in the moderation queue.
+func (db *DB) UpdateChirp(chirpId int, body string, flagReasons []string) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpId)
+		if !ok {
+			return errChirpNotFound
+		}
+
+		revisions := tx.ChirpRevisions(chirpId)
+		revisions = append(revisions, ChirpRevision{
+			ChirpId:   chirpId,
+			Version:   len(revisions) + 1,
+			Body:      chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+		})
+		if err := tx.PutChirpRevisions(chirpId, revisions); err != nil {
+			return err
+		}
+
+		entities, err := chirpEntities(body, tx.userByUsername)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		chirp.Body = body
+		chirp.Entities = entities
+		chirp.UpdatedAt = now
+		chirp.Edited = true
+		chirp.EditedAt = &now
+
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+
+		if len(flagReasons) > 0 {
+			_, err = flagChirp(tx, chirpId, flagReasons)
+		}
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	chirp, ok := data.Chirps[chirpId]
-	if !ok {
-		return Chirp{}, nil
-	}
-
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(chirpId int) error {
-	data, err := db.loadDB()
-	if err != nil {
-		return err
+// GetChirpRevisions returns the previous bodies of a chirp, oldest first
+func (db *DB) GetChirpRevisions(chirpId int) ([]ChirpRevision, error) {
+	revisions := []ChirpRevision{}
+	err := db.View(func(tx *Tx) error {
+		revisions = append(revisions, tx.ChirpRevisions(chirpId)...)
+		return nil
+	})
+
+	return revisions, err
+}
+
+// GetChirpThread returns the whole conversation the chirp is part of,
+// starting from the chirp that began it
+func (db *DB) GetChirpThread(chirpId int) (ChirpThread, error) {
+	var thread ChirpThread
+	err := db.View(func(tx *Tx) error {
+		root, ok := tx.Chirp(chirpId)
+		if !ok {
+			return nil
+		}
+
+		for root.InReplyToId != 0 {
+			parent, ok := tx.Chirp(root.InReplyToId)
+			if !ok {
+				break
+			}
+			root = parent
+		}
+
+		chirps := []Chirp{root}
+		for i := 0; i < len(chirps); i++ {
+			for _, id := range tx.Replies(chirps[i].Id) {
+				reply, _ := tx.Chirp(id)
+				chirps = append(chirps, reply)
+			}
+		}
+
+		thread = buildThread(root.Id, chirps)
+		return nil
+	})
+
+	return thread, err
+}
+
+// deleteChirp removes a chirp or turns it into a tombstone if it has replies.
+// A tombstone is removed along with its last reply.
+func deleteChirp(tx *Tx, chirpId int) error {
+	chirp, ok := tx.Chirp(chirpId)
+	if !ok {
+		return nil
 	}
+	wasDeleted := chirp.Deleted
+	// a chirp in the trash was already taken off its parent's reply count
+	wasCounted := !chirp.Removed()
 
-	delete(data.Chirps, chirpId)
+	// plain rechirps have nothing to show without the chirp they re-share
+	for _, id := range slices.Clone(tx.Rechirps(chirpId)) {
+		if err := deleteChirp(tx, id); err != nil {
+			return err
+		}
+	}
 
-	if err := db.writeDB(data); err != nil {
-		return err
+	if len(tx.ChirpRevisions(chirpId)) > 0 {
+		if err := tx.DeleteChirpRevisions(chirpId); err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
+	for _, userId := range slices.Clone(tx.LikesOf(chirpId)) {
+		if err := tx.DeleteLike(chirpId, userId); err != nil {
+			return err
+		}
+	}
 
-func (db *DB) CreateUser(email, password string) (User, error) {
-	data, err := db.loadDB()
-	if err != nil {
-		return User{}, err
+	if _, ok := tx.ModerationItem(chirpId); ok {
+		if err := tx.DeleteModerationItem(chirpId); err != nil {
+			return err
+		}
 	}
 
-	if db.checkDuplicateEmail(email) {
-		return User{}, nil
+	if len(tx.Replies(chirpId)) > 0 {
+		if wasDeleted {
+			return nil
+		}
+
+		if err := tx.PutChirp(tombstone(chirp)); err != nil {
+			return err
+		}
+	} else if err := tx.DeleteChirp(chirpId); err != nil {
+		return err
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return User{}, err
+	if chirp.InReplyToId == 0 {
+		return nil
 	}
 
-	user := User{
-		Id:          len(data.Users) + 1,
-		Email:       email,
-		Password:    string(hash),
-		IsChirpyRed: false,
+	parent, ok := tx.Chirp(chirp.InReplyToId)
+	if !ok {
+		return nil
 	}
 
-	data.Users[user.Id] = user
+	if wasCounted {
+		parent.ReplyCount--
+		if err := tx.PutChirp(parent); err != nil {
+			return err
+		}
+	}
 
-	if err := db.writeDB(data); err != nil {
-		return user, err
+	if parent.Deleted && len(tx.Replies(parent.Id)) == 0 {
+		return deleteChirp(tx, parent.Id)
 	}
 
-	return user, nil
+	return nil
 }
 
-func (db *DB) UpdateUser(userId int, email, password string) (User, error) {
-	data, err := db.loadDB()
+func (db *DB) CreateUser(email, password, username string) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return User{}, err
 	}
 
-	user, err := db.GetUserById(userId)
-	if err != nil {
-		return User{}, err
-	}
+	var user User
+	err = db.Update(func(tx *Tx) error {
+		if _, exists := tx.UserByEmail(email); exists {
+			return nil
+		}
 
-	if email != "" {
-		user.Email = email
-	}
+		if _, taken := tx.UserByUsername(username); taken {
+			return ErrUsernameTaken
+		}
 
-	if password != "" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		id, err := tx.nextId(tableUsers)
 		if err != nil {
-			return User{}, err
+			return err
 		}
 
-		user.Password = string(hash)
-	}
-
-	data.Users[user.Id] = user
+		now := time.Now().UTC()
+		user = User{
+			Id:          id,
+			Email:       email,
+			Username:    username,
+			Password:    string(hash),
+			IsChirpyRed: false,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
 
-	if err := db.writeDB(data); err != nil {
-		return user, err
+		return tx.PutUser(user)
+	})
+	if err != nil {
+		return User{}, err
 	}
 
 	return user, nil
 }
 
-func (db *DB) UpgradeToChirpyRed(userId int) error {
-	data, err := db.loadDB()
-	if err != nil {
-		return err
+// UpdateUser changes the non-empty fields of a user
+func (db *DB) UpdateUser(userId int, email, password, username string) (User, error) {
+	var hash []byte
+	if password != "" {
+		var err error
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, err
+		}
 	}
 
-	user, err := db.GetUserById(userId)
-	if err != nil {
-		return err
-	}
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userId)
+		if !ok {
+			return errUserNotFound
+		}
 
-	user.IsChirpyRed = true
-	data.Users[user.Id] = user
+		if email != "" {
+			if other, taken := tx.UserByEmail(email); taken && other.Id != userId {
+				return ErrEmailTaken
+			}
+			user.Email = email
+		}
 
-	if err := db.writeDB(data); err != nil {
-		return err
-	}
+		if username != "" {
+			if other, taken := tx.UserByUsername(username); taken && other.Id != userId {
+				return ErrUsernameTaken
+			}
+			user.Username = username
+		}
 
-	return nil
-}
+		if hash != nil {
+			user.Password = string(hash)
+		}
 
-func (db *DB) RevokeToken(token string) error {
-	data, err := db.loadDB()
+		user.UpdatedAt = time.Now().UTC()
+		return tx.PutUser(user)
+	})
 	if err != nil {
-		return err
+		return User{}, err
 	}
 
-	data.RevokedTokens[token] = RevokedToken{
-		RevokedAt: time.Now().UTC(),
-	}
+	return user, nil
+}
 
-	if err := db.writeDB(data); err != nil {
-		return err
-	}
+func (db *DB) UpgradeToChirpyRed(userId int) error {
+	return db.Update(func(tx *Tx) error {
+		user, ok := tx.User(userId)
+		if !ok {
+			return errUserNotFound
+		}
 
-	return nil
+		user.IsChirpyRed = true
+		user.UpdatedAt = time.Now().UTC()
+		return tx.PutUser(user)
+	})
+}
+
+// RevokeToken records a refresh token as revoked until it expires
+func (db *DB) RevokeToken(token string, expiresAt time.Time) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.PutRevokedToken(hashToken(token), RevokedToken{
+			RevokedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt.UTC(),
+		})
+	})
 }
 
 func (db *DB) IsTokenRevoked(token string) bool {
-	data, err := db.loadDB()
-	if err != nil {
-		return true
-	}
+	revoked := true
+	db.View(func(tx *Tx) error {
+		_, revoked = tx.RevokedToken(hashToken(token))
+		return nil
+	})
 
-	_, ok := data.RevokedTokens[token]
-	return ok
+	return revoked
 }
 
-func (db *DB) checkDuplicateEmail(email string) bool {
-	data, err := db.loadDB()
-	if err != nil {
-		return true
-	}
+// PurgeExpiredTokens removes revoked tokens that have expired by now,
+// an expired token is rejected anyway so the entry is no longer needed
+func (db *DB) PurgeExpiredTokens(now time.Time) (int, error) {
+	purged := 0
+	err := db.Update(func(tx *Tx) error {
+		for hash, revoked := range tx.data.RevokedTokens {
+			if revoked.ExpiresAt.After(now) {
+				continue
+			}
 
-	for _, user := range data.Users {
-		if user.Email == email {
-			return true
+			if err := tx.DeleteRevokedToken(hash); err != nil {
+				return err
+			}
+			purged++
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return false
+	return purged, nil
 }
 
 func (db *DB) VerifyPassword(email, password string) (User, error) {
-	user, err := db.getUserByEmail(email)
+	var user User
+	err := db.View(func(tx *Tx) error {
+		user, _ = tx.UserByEmail(email)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -335,32 +831,19 @@ func (db *DB) VerifyPassword(email, password string) (User, error) {
 	return user, nil
 }
 
-func (db *DB) getUserByEmail(email string) (User, error) {
-	data, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	for _, user := range data.Users {
-		if user.Email == email {
-			return user, nil
-		}
-	}
-
-	return User{}, nil
-}
-
 func (db *DB) GetUserById(id int) (User, error) {
-	data, err := db.loadDB()
+	var user User
+	err := db.View(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(id)
+		if !ok {
+			return errUserNotFound
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	for _, user := range data.Users {
-		if user.Id == id {
-			return user, nil
-		}
-	}
-
-	return User{}, errors.New("User not found")
+	return user, nil
 }
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// maxUsernameLength is the longest username an @mention can refer to
+const maxUsernameLength = 15
+
+// ChirpEntities are the #hashtags and @mentions found in a chirp body
+type ChirpEntities struct {
+	Hashtags []Hashtag `json:"hashtags,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+}
+
+// Hashtag is a #hashtag in a chirp body. Tag is lowercased and without the #,
+// Start and End are the rune offsets of the hashtag in the body, # included.
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Mention is an @mention of a user in a chirp body. Start and End are the
+// rune offsets of the mention in the body, @ included.
+type Mention struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// ValidUsername reports whether name can be used as a username,
+// 1 to 15 ascii letters, digits or underscores
+func ValidUsername(name string) bool {
+	if name == "" || len(name) > maxUsernameLength {
+		return false
+	}
+
+	for _, r := range name {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeHashtag returns the tag a #hashtag is stored under, ok is false
+// when tag isn't a valid hashtag
+func NormalizeHashtag(tag string) (string, bool) {
+	tag = strings.TrimPrefix(tag, "#")
+
+	hasLetter := false
+	for _, r := range tag {
+		if !isEntityRune(r) {
+			return "", false
+		}
+		hasLetter = hasLetter || unicode.IsLetter(r)
+	}
+	if !hasLetter {
+		return "", false
+	}
+
+	return strings.ToLower(tag), true
+}
+
+// isEntityRune reports whether r can be part of a hashtag or mention
+func isEntityRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parseEntities finds the #hashtags and @mentions in a chirp body. A # or @
+// only starts one at the beginning of a word, so email addresses aren't
+// mentions. Mentions are left unresolved.
+func parseEntities(body string) ChirpEntities {
+	entities := ChirpEntities{}
+	runes := []rune(body)
+	for start := 0; start < len(runes); start++ {
+		sigil := runes[start]
+		if sigil != '#' && sigil != '@' {
+			continue
+		}
+		if start > 0 && isEntityRune(runes[start-1]) {
+			continue
+		}
+
+		end := start + 1
+		for end < len(runes) && isEntityRune(runes[end]) {
+			end++
+		}
+
+		word := string(runes[start+1 : end])
+		if sigil == '#' {
+			if tag, ok := NormalizeHashtag(word); ok {
+				entities.Hashtags = append(entities.Hashtags, Hashtag{Tag: tag, Start: start, End: end})
+			}
+		} else if ValidUsername(word) {
+			entities.Mentions = append(entities.Mentions, Mention{Username: word, Start: start, End: end})
+		}
+
+		start = end - 1
+	}
+
+	return entities
+}
+
+// chirpEntities parses the entities of a chirp body, resolving mentions
+// with lookup and dropping those of usernames that don't exist
+func chirpEntities(body string, lookup func(username string) (User, error)) (ChirpEntities, error) {
+	entities := parseEntities(body)
+
+	var mentions []Mention
+	for _, mention := range entities.Mentions {
+		user, err := lookup(mention.Username)
+		if errors.Is(err, errUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return ChirpEntities{}, err
+		}
+
+		mention.UserId = user.Id
+		mention.Username = user.Username
+		mentions = append(mentions, mention)
+	}
+	entities.Mentions = mentions
+
+	return entities, nil
+}
+
+// hasHashtag reports whether the entities include tag
+func (e ChirpEntities) hasHashtag(tag string) bool {
+	for _, hashtag := range e.Hashtags {
+		if hashtag.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// mentions reports whether the entities include a mention of userId
+func (e ChirpEntities) mentions(userId int) bool {
+	for _, mention := range e.Mentions {
+		if mention.UserId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// sqliteUserByUsername looks users up by username ignoring case for chirpEntities
+func sqliteUserByUsername(tx *sql.Tx) func(username string) (User, error) {
+	return func(username string) (User, error) {
+		return scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ? COLLATE NOCASE`, username))
+	}
+}
+
+// checkSQLiteUsername fails with ErrUsernameTaken when a user
+// other than userId already has username
+func checkSQLiteUsername(tx *sql.Tx, userId int, username string) error {
+	if username == "" {
+		return nil
+	}
+
+	user, err := sqliteUserByUsername(tx)(username)
+	if errors.Is(err, errUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Id != userId {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+// saveSQLiteEntities stores the entities of a chirp
+// and the rows looking it up by hashtag and mention
+func saveSQLiteEntities(tx *sql.Tx, chirpId int, entities ChirpEntities) error {
+	var encoded any
+	if len(entities.Hashtags) > 0 || len(entities.Mentions) > 0 {
+		data, err := json.Marshal(entities)
+		if err != nil {
+			return err
+		}
+		encoded = string(data)
+	}
+
+	if _, err := tx.Exec(`UPDATE chirps SET entities = ? WHERE id = ?`, encoded, chirpId); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM chirp_hashtags WHERE chirp_id = ?`, chirpId); err != nil {
+		return err
+	}
+	for _, hashtag := range entities.Hashtags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO chirp_hashtags (tag, chirp_id) VALUES (?, ?)`,
+			hashtag.Tag, chirpId); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM chirp_mentions WHERE chirp_id = ?`, chirpId); err != nil {
+		return err
+	}
+	for _, mention := range entities.Mentions {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO chirp_mentions (user_id, chirp_id) VALUES (?, ?)`,
+			mention.UserId, chirpId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseEntities(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantHashtags []Hashtag
+		wantMentions []Mention
+	}{
+		{
+			name: "none",
+			body: "just words",
+		},
+		{
+			name:         "hashtag is lowercased",
+			body:         "#GoLang rocks",
+			wantHashtags: []Hashtag{{Tag: "golang", Start: 0, End: 7}},
+		},
+		{
+			name:         "offsets count runes",
+			body:         "héllo #café",
+			wantHashtags: []Hashtag{{Tag: "café", Start: 6, End: 11}},
+		},
+		{
+			name:         "punctuation ends an entity",
+			body:         "(#go, @bob!)",
+			wantHashtags: []Hashtag{{Tag: "go", Start: 1, End: 4}},
+			wantMentions: []Mention{{Username: "bob", Start: 6, End: 10}},
+		},
+		{
+			name: "only digits isn't a hashtag",
+			body: "#2024",
+		},
+		{
+			name: "email address isn't a mention",
+			body: "mail a@example.com",
+		},
+		{
+			name: "username too long",
+			body: "@abcdefghijklmnop",
+		},
+		{
+			name: "lone sigils",
+			body: "# @ #",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entities := parseEntities(tt.body)
+
+			if !slices.Equal(entities.Hashtags, tt.wantHashtags) {
+				t.Errorf("hashtags = %+v, want %+v", entities.Hashtags, tt.wantHashtags)
+			}
+			if !slices.Equal(entities.Mentions, tt.wantMentions) {
+				t.Errorf("mentions = %+v, want %+v", entities.Mentions, tt.wantMentions)
+			}
+		})
+	}
+}
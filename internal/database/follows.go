@@ -0,0 +1,307 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Follow records that a user follows another
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// followKey is the key a follow is stored under in the json database
+func followKey(followerId, followeeId int) string {
+	return strconv.Itoa(followerId) + ":" + strconv.Itoa(followeeId)
+}
+
+// FollowUser makes follower follow followee and returns the followee,
+// following someone again is a no-op
+func (db *DB) FollowUser(followerId, followeeId int) (User, error) {
+	return db.updateFollow(followerId, followeeId, true)
+}
+
+// UnfollowUser stops follower following followee and returns the followee
+func (db *DB) UnfollowUser(followerId, followeeId int) (User, error) {
+	return db.updateFollow(followerId, followeeId, false)
+}
+
+// updateFollow adds or removes a follow along with the counts on both users.
+// A user can't follow someone who blocks them.
+func (db *DB) updateFollow(followerId, followeeId int, follow bool) (User, error) {
+	var followee User
+	err := db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(followerId); !ok {
+			return errUserNotFound
+		}
+		if _, ok := tx.User(followeeId); !ok {
+			return errUserNotFound
+		}
+
+		if follow {
+			if err := checkBlocked(tx, followeeId, followerId); err != nil {
+				return err
+			}
+		}
+
+		if err := setFollow(tx, followerId, followeeId, follow); err != nil {
+			return err
+		}
+
+		followee, _ = tx.User(followeeId)
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return followee, nil
+}
+
+// setFollow adds or removes a follow between two existing users along with their counts
+func setFollow(tx *Tx, followerId, followeeId int, follow bool) error {
+	_, following := tx.Follow(followerId, followeeId)
+	if following == follow {
+		return nil
+	}
+
+	delta := 1
+	if follow {
+		err := tx.PutFollow(Follow{FollowerId: followerId, FolloweeId: followeeId, CreatedAt: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := tx.DeleteFollow(followerId, followeeId); err != nil {
+			return err
+		}
+		delta = -1
+	}
+
+	follower, _ := tx.User(followerId)
+	follower.FollowingCount += delta
+	if err := tx.PutUser(follower); err != nil {
+		return err
+	}
+
+	followee, _ := tx.User(followeeId)
+	followee.FollowerCount += delta
+	return tx.PutUser(followee)
+}
+
+// GetFollowers returns the users following a user, most recent follower first
+func (db *DB) GetFollowers(userId int) ([]User, error) {
+	return db.followUsers(userId, true)
+}
+
+// GetFollowing returns the users a user follows, most recently followed first
+func (db *DB) GetFollowing(userId int) ([]User, error) {
+	return db.followUsers(userId, false)
+}
+
+// followUsers returns the followers of a user or the users they follow, newest follow first
+func (db *DB) followUsers(userId int, followers bool) ([]User, error) {
+	users := []User{}
+	err := db.View(func(tx *Tx) error {
+		follows := []Follow{}
+		if followers {
+			for _, followerId := range tx.Followers(userId) {
+				follow, _ := tx.Follow(followerId, userId)
+				follows = append(follows, follow)
+			}
+		} else {
+			for _, followeeId := range tx.Following(userId) {
+				follow, _ := tx.Follow(userId, followeeId)
+				follows = append(follows, follow)
+			}
+		}
+
+		slices.SortFunc(follows, func(a, b Follow) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(b.FollowerId, a.FollowerId); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.FolloweeId, a.FolloweeId)
+		})
+
+		for _, follow := range follows {
+			otherId := follow.FolloweeId
+			if followers {
+				otherId = follow.FollowerId
+			}
+
+			user, _ := tx.User(otherId)
+			users = append(users, user)
+		}
+		return nil
+	})
+
+	return users, err
+}
+
+// GetTimeline returns a page of the chirps of the user and everyone they
+// follow, newest first, merging each author's chirp ids
+func (db *DB) GetTimeline(userId int, query ChirpQuery) (ChirpPage, error) {
+	page := ChirpPage{Chirps: []Chirp{}}
+	err := db.View(func(tx *Tx) error {
+		lists := [][]int{tx.data.idx.chirpsByAuthor[userId]}
+		for _, followeeId := range tx.Following(userId) {
+			lists = append(lists, tx.data.idx.chirpsByAuthor[followeeId])
+		}
+
+		query.silenced = silencedBy(tx, query.MutedBy)
+		mergeIdsDesc(lists, query.After, func(id int) bool {
+			return page.add(query, tx.data.Chirps[id])
+		})
+		return nil
+	})
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	return page, nil
+}
+
+// FollowUser makes follower follow followee and returns the followee,
+// following someone again is a no-op
+func (db *SQLiteDB) FollowUser(followerId, followeeId int) (User, error) {
+	return db.updateFollow(followerId, followeeId, func(tx *sql.Tx) (int64, error) {
+		if err := checkSQLiteBlocked(tx, followeeId, followerId); err != nil {
+			return 0, err
+		}
+
+		res, err := tx.Exec(`
+			INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (follower_id, followee_id) DO NOTHING`,
+			followerId, followeeId, time.Now().UTC())
+		if err != nil {
+			return 0, err
+		}
+
+		return res.RowsAffected()
+	})
+}
+
+// UnfollowUser stops follower following followee and returns the followee
+func (db *SQLiteDB) UnfollowUser(followerId, followeeId int) (User, error) {
+	return db.updateFollow(followerId, followeeId, func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerId, followeeId)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := res.RowsAffected()
+		return -n, err
+	})
+}
+
+// updateFollow runs change, which returns how it moved the follow counts,
+// and applies that to both users in the same transaction
+func (db *SQLiteDB) updateFollow(followerId, followeeId int, change func(tx *sql.Tx) (int64, error)) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	var users int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE id IN (?, ?)`, followerId, followeeId).Scan(&users)
+	if err != nil {
+		return User{}, err
+	}
+	if users != 2 {
+		return User{}, errUserNotFound
+	}
+
+	delta, err := change(tx)
+	if err != nil {
+		return User{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET following_count = following_count + ? WHERE id = ?`, delta, followerId); err != nil {
+		return User{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET follower_count = follower_count + ? WHERE id = ?`, delta, followeeId); err != nil {
+		return User{}, err
+	}
+
+	followee, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, followeeId))
+	if err != nil {
+		return User{}, err
+	}
+
+	return followee, tx.Commit()
+}
+
+// GetFollowers returns the users following a user, most recent follower first
+func (db *SQLiteDB) GetFollowers(userId int) ([]User, error) {
+	return db.queryUsers(`
+		SELECT `+qualifiedUserColumns+` FROM follows
+		JOIN users ON users.id = follows.follower_id
+		WHERE follows.followee_id = ?
+		ORDER BY follows.created_at DESC, follows.follower_id DESC`, userId)
+}
+
+// GetFollowing returns the users a user follows, most recently followed first
+func (db *SQLiteDB) GetFollowing(userId int) ([]User, error) {
+	return db.queryUsers(`
+		SELECT `+qualifiedUserColumns+` FROM follows
+		JOIN users ON users.id = follows.followee_id
+		WHERE follows.follower_id = ?
+		ORDER BY follows.created_at DESC, follows.followee_id DESC`, userId)
+}
+
+// queryUsers runs a query selecting userColumns
+func (db *SQLiteDB) queryUsers(query string, args ...any) ([]User, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetTimeline returns a page of the chirps of the user and everyone they follow, newest first
+func (db *SQLiteDB) GetTimeline(userId int, query ChirpQuery) (ChirpPage, error) {
+	limit := -1
+	if query.Limit > 0 {
+		limit = query.Limit + 1
+	}
+
+	since, until := nullTime(query.Since), nullTime(query.Until)
+	rows, err := db.conn.Query(`
+		SELECT `+chirpColumns+` FROM chirps
+		WHERE (author_id = ? OR author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?))
+		AND NOT deleted AND deleted_at IS NULL
+		AND (NOT hidden OR author_id = ?)
+		AND `+silencedClause+`
+		AND (? = 0 OR id < ?)
+		AND (? IS NULL OR created_at >= ?)
+		AND (? IS NULL OR created_at < ?)
+		ORDER BY id DESC
+		LIMIT ?`,
+		userId, userId, query.ViewerId, query.MutedBy, query.MutedBy, query.MutedBy, query.After, query.After, since, since, until, until, limit)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	return scanChirpPage(rows, query)
+}
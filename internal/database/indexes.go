@@ -0,0 +1,463 @@
+package database
+
+import (
+	"container/heap"
+	"slices"
+	"strings"
+
+	"github.com/natac13/go-chirpy/internal/search"
+)
+
+// indexes are secondary lookups over DBStructure. They aren't persisted,
+// they are rebuilt on load and kept up to date by applyOp.
+type indexes struct {
+	userByEmail map[string]int
+	// userByUsername is keyed by lowercased username
+	userByUsername map[string]int
+	// chirpIds holds every chirp id in ascending order
+	chirpIds []int
+	// chirpsByAuthor holds each author's chirp ids in ascending order
+	chirpsByAuthor map[int][]int
+	// repliesByParent holds the ids of the direct replies to each chirp,
+	// tombstones included, in ascending order
+	repliesByParent map[int][]int
+	// likesByChirp holds the ids of the users who like each chirp and
+	// likesByUser the ids of the chirps each user likes, in ascending order
+	likesByChirp map[int][]int
+	likesByUser  map[int][]int
+	// chirpsByHashtag holds the ids of the chirps with each tag and chirpsMentioning
+	// the ids of the chirps mentioning each user, in ascending order
+	chirpsByHashtag  map[string][]int
+	chirpsMentioning map[int][]int
+	// chirpsByTerm is the inverted index for search, holding the ids of the
+	// chirps containing each term in ascending order. searchDocs counts the
+	// chirps with at least one token and searchTokens the tokens across them.
+	chirpsByTerm map[string][]int
+	searchDocs   int
+	searchTokens int
+	// rechirpsOf holds the ids of the plain rechirps of each chirp in ascending order
+	rechirpsOf map[int][]int
+	// followersOf holds the ids of the users following each user and
+	// followingOf the ids of the users each user follows, in ascending order
+	followersOf map[int][]int
+	followingOf map[int][]int
+	// reportsOfChirp and reportsOfUser hold the ids of the reports
+	// about each chirp and each user in ascending order
+	reportsOfChirp map[int][]int
+	reportsOfUser  map[int][]int
+	// blockedBy holds the ids of the users each user blocks and
+	// mutedBy those each user mutes, in ascending order
+	blockedBy map[int][]int
+	mutedBy   map[int][]int
+}
+
+// buildIndexes rebuilds every index from scratch
+func (data *DBStructure) buildIndexes() {
+	data.idx = &indexes{
+		userByEmail:      map[string]int{},
+		userByUsername:   map[string]int{},
+		chirpsByHashtag:  map[string][]int{},
+		chirpsMentioning: map[int][]int{},
+		chirpsByTerm:     map[string][]int{},
+		chirpsByAuthor:   map[int][]int{},
+		repliesByParent:  map[int][]int{},
+		likesByChirp:     map[int][]int{},
+		likesByUser:      map[int][]int{},
+		rechirpsOf:       map[int][]int{},
+		followersOf:      map[int][]int{},
+		followingOf:      map[int][]int{},
+		reportsOfChirp:   map[int][]int{},
+		reportsOfUser:    map[int][]int{},
+		blockedBy:        map[int][]int{},
+		mutedBy:          map[int][]int{},
+	}
+
+	for _, user := range data.Users {
+		data.idx.addUser(user)
+	}
+
+	for _, chirp := range data.Chirps {
+		data.idx.chirpIds = append(data.idx.chirpIds, chirp.Id)
+		data.idx.chirpsByAuthor[chirp.AuthorId] = append(data.idx.chirpsByAuthor[chirp.AuthorId], chirp.Id)
+		if chirp.InReplyToId != 0 {
+			data.idx.repliesByParent[chirp.InReplyToId] = append(data.idx.repliesByParent[chirp.InReplyToId], chirp.Id)
+		}
+		if chirp.RechirpOfId != 0 {
+			data.idx.rechirpsOf[chirp.RechirpOfId] = append(data.idx.rechirpsOf[chirp.RechirpOfId], chirp.Id)
+		}
+		for _, hashtag := range chirp.Entities.Hashtags {
+			data.idx.chirpsByHashtag[hashtag.Tag] = append(data.idx.chirpsByHashtag[hashtag.Tag], chirp.Id)
+		}
+		for _, mention := range chirp.Entities.Mentions {
+			data.idx.chirpsMentioning[mention.UserId] = append(data.idx.chirpsMentioning[mention.UserId], chirp.Id)
+		}
+
+		tokens := search.Tokenize(chirp.Body)
+		for _, term := range search.Terms(tokens) {
+			data.idx.chirpsByTerm[term] = append(data.idx.chirpsByTerm[term], chirp.Id)
+		}
+		data.idx.countTokens(tokens, 1)
+	}
+	slices.Sort(data.idx.chirpIds)
+	for _, ids := range data.idx.chirpsByAuthor {
+		slices.Sort(ids)
+	}
+	for _, ids := range data.idx.repliesByParent {
+		slices.Sort(ids)
+	}
+	for _, ids := range data.idx.rechirpsOf {
+		slices.Sort(ids)
+	}
+	// a chirp can use a tag or mention a user more than once
+	for tag, ids := range data.idx.chirpsByHashtag {
+		slices.Sort(ids)
+		data.idx.chirpsByHashtag[tag] = slices.Compact(ids)
+	}
+	for userId, ids := range data.idx.chirpsMentioning {
+		slices.Sort(ids)
+		data.idx.chirpsMentioning[userId] = slices.Compact(ids)
+	}
+	for _, ids := range data.idx.chirpsByTerm {
+		slices.Sort(ids)
+	}
+
+	for _, like := range data.Likes {
+		data.idx.addLike(like)
+	}
+
+	for _, follow := range data.Follows {
+		data.idx.addFollow(follow)
+	}
+
+	for _, report := range data.Reports {
+		data.idx.addReport(report)
+	}
+
+	for _, block := range data.Blocks {
+		data.idx.addBlock(block)
+	}
+
+	for _, mute := range data.Mutes {
+		data.idx.addMute(mute)
+	}
+}
+
+// update moves a record from its previous to its current value in the indexes
+func (idx *indexes) update(table string, previous any, hadPrevious bool, current any, hasCurrent bool) {
+	switch table {
+	case tableUsers:
+		if hadPrevious {
+			idx.removeUser(previous.(User))
+		}
+		if hasCurrent {
+			idx.addUser(current.(User))
+		}
+	case tableChirps:
+		if hadPrevious {
+			idx.removeChirp(previous.(Chirp))
+		}
+		if hasCurrent {
+			idx.addChirp(current.(Chirp))
+		}
+	case tableLikes:
+		if hadPrevious {
+			idx.removeLike(previous.(Like))
+		}
+		if hasCurrent {
+			idx.addLike(current.(Like))
+		}
+	case tableFollows:
+		if hadPrevious {
+			idx.removeFollow(previous.(Follow))
+		}
+		if hasCurrent {
+			idx.addFollow(current.(Follow))
+		}
+	case tableReports:
+		if hadPrevious {
+			idx.removeReport(previous.(Report))
+		}
+		if hasCurrent {
+			idx.addReport(current.(Report))
+		}
+	case tableBlocks:
+		if hadPrevious {
+			idx.removeBlock(previous.(Block))
+		}
+		if hasCurrent {
+			idx.addBlock(current.(Block))
+		}
+	case tableMutes:
+		if hadPrevious {
+			idx.removeMute(previous.(Mute))
+		}
+		if hasCurrent {
+			idx.addMute(current.(Mute))
+		}
+	}
+}
+
+func (idx *indexes) addUser(user User) {
+	idx.userByEmail[user.Email] = user.Id
+	if user.Username != "" {
+		idx.userByUsername[strings.ToLower(user.Username)] = user.Id
+	}
+}
+
+func (idx *indexes) removeUser(user User) {
+	if idx.userByEmail[user.Email] == user.Id {
+		delete(idx.userByEmail, user.Email)
+	}
+	if username := strings.ToLower(user.Username); idx.userByUsername[username] == user.Id {
+		delete(idx.userByUsername, username)
+	}
+}
+
+func (idx *indexes) addChirp(chirp Chirp) {
+	idx.chirpIds = insertSorted(idx.chirpIds, chirp.Id)
+	idx.chirpsByAuthor[chirp.AuthorId] = insertSorted(idx.chirpsByAuthor[chirp.AuthorId], chirp.Id)
+	if chirp.InReplyToId != 0 {
+		idx.repliesByParent[chirp.InReplyToId] = insertSorted(idx.repliesByParent[chirp.InReplyToId], chirp.Id)
+	}
+	if chirp.RechirpOfId != 0 {
+		idx.rechirpsOf[chirp.RechirpOfId] = insertSorted(idx.rechirpsOf[chirp.RechirpOfId], chirp.Id)
+	}
+	for _, hashtag := range chirp.Entities.Hashtags {
+		idx.chirpsByHashtag[hashtag.Tag] = insertSorted(idx.chirpsByHashtag[hashtag.Tag], chirp.Id)
+	}
+	for _, mention := range chirp.Entities.Mentions {
+		idx.chirpsMentioning[mention.UserId] = insertSorted(idx.chirpsMentioning[mention.UserId], chirp.Id)
+	}
+
+	tokens := search.Tokenize(chirp.Body)
+	for _, term := range search.Terms(tokens) {
+		idx.chirpsByTerm[term] = insertSorted(idx.chirpsByTerm[term], chirp.Id)
+	}
+	idx.countTokens(tokens, 1)
+}
+
+func (idx *indexes) removeChirp(chirp Chirp) {
+	idx.chirpIds = removeSorted(idx.chirpIds, chirp.Id)
+
+	removeFromGroup(idx.chirpsByAuthor, chirp.AuthorId, chirp.Id)
+	if chirp.InReplyToId != 0 {
+		removeFromGroup(idx.repliesByParent, chirp.InReplyToId, chirp.Id)
+	}
+	if chirp.RechirpOfId != 0 {
+		removeFromGroup(idx.rechirpsOf, chirp.RechirpOfId, chirp.Id)
+	}
+	for _, hashtag := range chirp.Entities.Hashtags {
+		removeFromGroup(idx.chirpsByHashtag, hashtag.Tag, chirp.Id)
+	}
+	for _, mention := range chirp.Entities.Mentions {
+		removeFromGroup(idx.chirpsMentioning, mention.UserId, chirp.Id)
+	}
+
+	tokens := search.Tokenize(chirp.Body)
+	for _, term := range search.Terms(tokens) {
+		removeFromGroup(idx.chirpsByTerm, term, chirp.Id)
+	}
+	idx.countTokens(tokens, -1)
+}
+
+// countTokens adds or, with sign -1, removes the tokens of a chirp from the search stats
+func (idx *indexes) countTokens(tokens []string, sign int) {
+	if len(tokens) > 0 {
+		idx.searchDocs += sign
+		idx.searchTokens += sign * len(tokens)
+	}
+}
+
+func (idx *indexes) addLike(like Like) {
+	idx.likesByChirp[like.ChirpId] = insertSorted(idx.likesByChirp[like.ChirpId], like.UserId)
+	idx.likesByUser[like.UserId] = insertSorted(idx.likesByUser[like.UserId], like.ChirpId)
+}
+
+func (idx *indexes) removeLike(like Like) {
+	removeFromGroup(idx.likesByChirp, like.ChirpId, like.UserId)
+	removeFromGroup(idx.likesByUser, like.UserId, like.ChirpId)
+}
+
+func (idx *indexes) addFollow(follow Follow) {
+	idx.followersOf[follow.FolloweeId] = insertSorted(idx.followersOf[follow.FolloweeId], follow.FollowerId)
+	idx.followingOf[follow.FollowerId] = insertSorted(idx.followingOf[follow.FollowerId], follow.FolloweeId)
+}
+
+func (idx *indexes) removeFollow(follow Follow) {
+	removeFromGroup(idx.followersOf, follow.FolloweeId, follow.FollowerId)
+	removeFromGroup(idx.followingOf, follow.FollowerId, follow.FolloweeId)
+}
+
+func (idx *indexes) addReport(report Report) {
+	if report.ChirpId != 0 {
+		idx.reportsOfChirp[report.ChirpId] = insertSorted(idx.reportsOfChirp[report.ChirpId], report.Id)
+	}
+	if report.UserId != 0 {
+		idx.reportsOfUser[report.UserId] = insertSorted(idx.reportsOfUser[report.UserId], report.Id)
+	}
+}
+
+func (idx *indexes) removeReport(report Report) {
+	if report.ChirpId != 0 {
+		removeFromGroup(idx.reportsOfChirp, report.ChirpId, report.Id)
+	}
+	if report.UserId != 0 {
+		removeFromGroup(idx.reportsOfUser, report.UserId, report.Id)
+	}
+}
+
+func (idx *indexes) addBlock(block Block) {
+	idx.blockedBy[block.BlockerId] = insertSorted(idx.blockedBy[block.BlockerId], block.BlockedId)
+}
+
+func (idx *indexes) removeBlock(block Block) {
+	removeFromGroup(idx.blockedBy, block.BlockerId, block.BlockedId)
+}
+
+func (idx *indexes) addMute(mute Mute) {
+	idx.mutedBy[mute.MuterId] = insertSorted(idx.mutedBy[mute.MuterId], mute.MutedId)
+}
+
+func (idx *indexes) removeMute(mute Mute) {
+	removeFromGroup(idx.mutedBy, mute.MuterId, mute.MutedId)
+}
+
+// removeFromGroup removes id from the ids grouped under key, dropping empty groups
+func removeFromGroup[K comparable](groups map[K][]int, key K, id int) {
+	ids := removeSorted(groups[key], id)
+	if len(ids) == 0 {
+		delete(groups, key)
+		return
+	}
+	groups[key] = ids
+}
+
+func insertSorted(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if found {
+		return ids
+	}
+	return slices.Insert(ids, i, id)
+}
+
+func removeSorted(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if !found {
+		return ids
+	}
+	return slices.Delete(ids, i, i+1)
+}
+
+// walkIds calls fn with the sorted ids past after in the given direction
+// until fn returns false, after 0 starts from the beginning
+func walkIds(ids []int, after int, desc bool, fn func(id int) bool) {
+	if desc {
+		end := len(ids)
+		if after != 0 {
+			end, _ = slices.BinarySearch(ids, after)
+		}
+		for i := end - 1; i >= 0; i-- {
+			if !fn(ids[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	start := 0
+	if after != 0 {
+		i, found := slices.BinarySearch(ids, after)
+		if found {
+			i++
+		}
+		start = i
+	}
+	for _, id := range ids[start:] {
+		if !fn(id) {
+			return
+		}
+	}
+}
+
+// intersectIds returns the ids in every list in ascending order,
+// each list must be sorted in ascending order
+func intersectIds(lists [][]int) []int {
+	if len(lists) == 0 {
+		return []int{}
+	}
+
+	lists = slices.Clone(lists)
+	slices.SortFunc(lists, func(a, b []int) int {
+		return len(a) - len(b)
+	})
+
+	ids := []int{}
+	for _, id := range lists[0] {
+		inAll := true
+		for _, list := range lists[1:] {
+			if _, found := slices.BinarySearch(list, id); !found {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// mergeIdsDesc calls fn with the ids of every list below before in descending
+// order until fn returns false, before 0 starts from the largest id.
+// Each list must be sorted in ascending order.
+func mergeIdsDesc(lists [][]int, before int, fn func(id int) bool) {
+	h := idHeap{}
+	for _, ids := range lists {
+		end := len(ids)
+		if before != 0 {
+			end, _ = slices.BinarySearch(ids, before)
+		}
+		if end > 0 {
+			h = append(h, idCursor{ids: ids, pos: end - 1})
+		}
+	}
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		if !fn(h[0].ids[h[0].pos]) {
+			return
+		}
+
+		if h[0].pos == 0 {
+			heap.Pop(&h)
+			continue
+		}
+		h[0].pos--
+		heap.Fix(&h, 0)
+	}
+}
+
+// idCursor points at the next id to take from a sorted list
+type idCursor struct {
+	ids []int
+	pos int
+}
+
+// idHeap is a max-heap of cursors ordered by the id they point at
+type idHeap []idCursor
+
+func (h idHeap) Len() int           { return len(h) }
+func (h idHeap) Less(i, j int) bool { return h[i].ids[h[i].pos] > h[j].ids[h[j].pos] }
+func (h idHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *idHeap) Push(x any) {
+	*h = append(*h, x.(idCursor))
+}
+
+func (h *idHeap) Pop() any {
+	old := *h
+	cursor := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return cursor
+}
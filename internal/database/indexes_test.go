@@ -0,0 +1,125 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIndexesFollowWrites(t *testing.T) {
+	tests := []struct {
+		name        string
+		fn          func(tx *Tx) error
+		wantAuthors map[int][]int
+		wantEmails  map[string]int
+	}{
+		{
+			name: "new chirps",
+			fn: func(tx *Tx) error {
+				for _, chirp := range []Chirp{{Id: 3, AuthorId: 1}, {Id: 4, AuthorId: 2}} {
+					if err := tx.PutChirp(chirp); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantAuthors: map[int][]int{1: {1, 2, 3}, 2: {4}},
+			wantEmails:  map[string]int{"a@example.com": 1, "b@example.com": 2},
+		},
+		{
+			name: "deleted chirp",
+			fn: func(tx *Tx) error {
+				return tx.DeleteChirp(1)
+			},
+			wantAuthors: map[int][]int{1: {2}},
+			wantEmails:  map[string]int{"a@example.com": 1, "b@example.com": 2},
+		},
+		{
+			name: "changed email",
+			fn: func(tx *Tx) error {
+				user, _ := tx.User(1)
+				user.Email = "c@example.com"
+				return tx.PutUser(user)
+			},
+			wantAuthors: map[int][]int{1: {1, 2}},
+			wantEmails:  map[string]int{"c@example.com": 1, "b@example.com": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, nil)
+			err := db.Update(func(tx *Tx) error {
+				for _, user := range []User{{Id: 1, Email: "a@example.com"}, {Id: 2, Email: "b@example.com"}} {
+					if err := tx.PutUser(user); err != nil {
+						return err
+					}
+				}
+				for _, chirp := range []Chirp{{Id: 1, AuthorId: 1}, {Id: 2, AuthorId: 1}} {
+					if err := tx.PutChirp(chirp); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.Update(tt.fn); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, idx := range []*indexes{db.data.idx, rebuiltIndexes(db.data)} {
+				for authorId, want := range tt.wantAuthors {
+					if got := idx.chirpsByAuthor[authorId]; !slices.Equal(got, want) {
+						t.Errorf("chirpsByAuthor[%d] = %v, want %v", authorId, got, want)
+					}
+				}
+				if len(idx.userByEmail) != len(tt.wantEmails) {
+					t.Errorf("userByEmail = %v, want %v", idx.userByEmail, tt.wantEmails)
+				}
+				for email, want := range tt.wantEmails {
+					if got := idx.userByEmail[email]; got != want {
+						t.Errorf("userByEmail[%s] = %d, want %d", email, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// rebuiltIndexes builds the indexes of data from scratch
+func rebuiltIndexes(data DBStructure) *indexes {
+	data.buildIndexes()
+	return data.idx
+}
+
+func TestMergeIdsDesc(t *testing.T) {
+	lists := [][]int{{1, 4, 7}, {2, 5}, {}, {3, 6, 8}}
+
+	tests := []struct {
+		name   string
+		before int
+		stop   int
+		want   []int
+	}{
+		{"all", 0, 0, []int{8, 7, 6, 5, 4, 3, 2, 1}},
+		{"before an id", 6, 0, []int{5, 4, 3, 2, 1}},
+		{"before the smallest id", 1, 0, []int{}},
+		{"stopped early", 0, 6, []int{8, 7, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			mergeIdsDesc(lists, tt.before, func(id int) bool {
+				got = append(got, id)
+				return id != tt.stop
+			})
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("merged %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
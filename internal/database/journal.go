@@ -0,0 +1,341 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// compactEvery is the number of journal entries after which
+// the journal is folded into a fresh snapshot of database.json
+const compactEvery = 100
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+const (
+	tableChirps         = "chirps"
+	tableUsers          = "users"
+	tableRevokedTokens  = "revoked_tokens"
+	tableSequences      = "sequences"
+	tableChirpRevisions = "chirp_revisions"
+	tableLikes          = "likes"
+	tableFollows        = "follows"
+	tableModeration     = "moderation"
+	tableReports        = "reports"
+	tableBlocks         = "blocks"
+	tableMutes          = "mutes"
+	tableScheduled      = "scheduled_chirps"
+)
+
+// journalOp is a single record level mutation of DBStructure
+type journalOp struct {
+	Op    string          `json:"op"`
+	Table string          `json:"table"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// journalEntry is one line of the journal, all of its ops are applied together
+type journalEntry struct {
+	Ops []journalOp `json:"ops"`
+}
+
+func putOp(table, key string, value any) (journalOp, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return journalOp{}, err
+	}
+
+	return journalOp{Op: opPut, Table: table, Key: key, Value: data}, nil
+}
+
+func deleteOp(table, key string) journalOp {
+	return journalOp{Op: opDelete, Table: table, Key: key}
+}
+
+// records is a table of DBStructure addressed by journal keys
+type records interface {
+	get(key string) (any, bool, error)
+	set(key string, value json.RawMessage) error
+	remove(key string) error
+}
+
+type intRecords[T any] map[int]T
+
+func (m intRecords[T]) get(key string) (any, bool, error) {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, ok := m[id]
+	return value, ok, nil
+}
+
+func (m intRecords[T]) set(key string, raw json.RawMessage) error {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return err
+	}
+
+	var value T
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	m[id] = value
+	return nil
+}
+
+func (m intRecords[T]) remove(key string) error {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return err
+	}
+
+	delete(m, id)
+	return nil
+}
+
+type stringRecords[T any] map[string]T
+
+func (m stringRecords[T]) get(key string) (any, bool, error) {
+	value, ok := m[key]
+	return value, ok, nil
+}
+
+func (m stringRecords[T]) set(key string, raw json.RawMessage) error {
+	var value T
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	m[key] = value
+	return nil
+}
+
+func (m stringRecords[T]) remove(key string) error {
+	delete(m, key)
+	return nil
+}
+
+// table returns the records a journal op with the given table name addresses
+func (data *DBStructure) table(name string) (records, error) {
+	switch name {
+	case tableChirps:
+		return intRecords[Chirp](data.Chirps), nil
+	case tableUsers:
+		return intRecords[User](data.Users), nil
+	case tableRevokedTokens:
+		return stringRecords[RevokedToken](data.RevokedTokens), nil
+	case tableSequences:
+		return stringRecords[int](data.Sequences), nil
+	case tableChirpRevisions:
+		return intRecords[[]ChirpRevision](data.ChirpRevisions), nil
+	case tableLikes:
+		return stringRecords[Like](data.Likes), nil
+	case tableFollows:
+		return stringRecords[Follow](data.Follows), nil
+	case tableModeration:
+		return intRecords[ModerationItem](data.Moderation), nil
+	case tableReports:
+		return intRecords[Report](data.Reports), nil
+	case tableBlocks:
+		return stringRecords[Block](data.Blocks), nil
+	case tableMutes:
+		return stringRecords[Mute](data.Mutes), nil
+	case tableScheduled:
+		return intRecords[ScheduledChirp](data.Scheduled), nil
+	default:
+		return nil, fmt.Errorf("unknown journal table %q", name)
+	}
+}
+
+// applyOp applies a journal op to the in memory structure
+func applyOp(data *DBStructure, op journalOp) error {
+	table, err := data.table(op.Table)
+	if err != nil {
+		return err
+	}
+
+	previous, hadPrevious, err := table.get(op.Key)
+	if err != nil {
+		return err
+	}
+
+	if op.Op == opDelete {
+		err = table.remove(op.Key)
+	} else {
+		err = table.set(op.Key, op.Value)
+	}
+	if err != nil {
+		return err
+	}
+
+	if data.idx != nil {
+		current, hasCurrent, err := table.get(op.Key)
+		if err != nil {
+			return err
+		}
+		data.idx.update(op.Table, previous, hadPrevious, current, hasCurrent)
+	}
+
+	return nil
+}
+
+// inverseOp returns the op that undoes op against the current state of data
+func inverseOp(data *DBStructure, op journalOp) (journalOp, error) {
+	table, err := data.table(op.Table)
+	if err != nil {
+		return journalOp{}, err
+	}
+
+	previous, ok, err := table.get(op.Key)
+	if err != nil {
+		return journalOp{}, err
+	}
+
+	if !ok {
+		return deleteOp(op.Table, op.Key), nil
+	}
+	return putOp(op.Table, op.Key, previous)
+}
+
+func (db *DB) journalPath() string {
+	return db.path + ".journal"
+}
+
+// replayJournal applies every complete journal entry on top of data
+// and returns the number of entries replayed. A torn final line left
+// behind by a crash mid append has no trailing newline and is ignored,
+// appendJournal cuts it off before writing the next entry.
+func (db *DB) replayJournal(data *DBStructure) (int, error) {
+	file, err := os.ReadFile(db.journalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	lines := bytes.Split(file, []byte("\n"))
+	if torn := lines[len(lines)-1]; len(torn) > 0 {
+		slog.Warn("DATABASE - Ignoring torn journal entry", "entry", len(lines))
+	}
+	lines = lines[:len(lines)-1]
+
+	entries := 0
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		line, err := unsealLine(db.aead, line)
+		if err != nil {
+			return entries, fmt.Errorf("journal entry %d: %w", i+1, err)
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return entries, fmt.Errorf("journal entry %d: %w", i+1, err)
+		}
+
+		for _, op := range entry.Ops {
+			if err := applyOp(data, op); err != nil {
+				return entries, err
+			}
+		}
+		entries++
+	}
+
+	return entries, nil
+}
+
+// appendJournal durably appends a single entry to the journal
+func (db *DB) appendJournal(ops []journalOp) error {
+	line, err := json.Marshal(journalEntry{Ops: ops})
+	if err != nil {
+		return err
+	}
+
+	line, err = sealLine(db.aead, line)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(db.journalPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	size, err := dropTornEntry(f, info.Size())
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		// don't leave a partial line for the next entry to be appended to
+		f.Truncate(size)
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Truncate(size)
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// dropTornEntry truncates a journal of size bytes that doesn't end in a newline
+// back to its last complete line and returns the new size. Otherwise the torn
+// entry and the one appended after it would read as a single corrupt line.
+func dropTornEntry(f *os.File, size int64) (int64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return size, nil
+	}
+
+	journal := make([]byte, size)
+	if _, err := f.ReadAt(journal, 0); err != nil {
+		return 0, err
+	}
+
+	end := int64(bytes.LastIndexByte(journal, '\n') + 1)
+	slog.Warn("DATABASE - Truncating torn journal entry", "bytes", size-end)
+	if err := f.Truncate(end); err != nil {
+		return 0, err
+	}
+
+	return end, nil
+}
+
+// truncateJournal empties the journal once its entries are part of the snapshot
+func (db *DB) truncateJournal() error {
+	err := os.Truncate(db.journalPath(), 0)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// newTestDB opens a fresh json database in a temp dir
+func newTestDB(t *testing.T, encryptionKey []byte) *DB {
+	t.Helper()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"), encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestData() DBStructure {
+	return DBStructure{
+		Chirps:        map[int]Chirp{},
+		Users:         map[int]User{},
+		RevokedTokens: map[string]RevokedToken{},
+		Sequences:     map[string]int{},
+
+		ChirpRevisions: map[int][]ChirpRevision{},
+		Likes:          map[string]Like{},
+		Follows:        map[string]Follow{},
+		Moderation:     map[int]ModerationItem{},
+		Reports:        map[int]Report{},
+		Blocks:         map[string]Block{},
+		Mutes:          map[string]Mute{},
+		Scheduled:      map[int]ScheduledChirp{},
+	}
+}
+
+// journalLine is a complete journal entry holding ops
+func journalLine(t *testing.T, ops ...journalOp) string {
+	t.Helper()
+
+	line, err := json.Marshal(journalEntry{Ops: ops})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line) + "\n"
+}
+
+func putChirpOp(t *testing.T, id int, body string) journalOp {
+	t.Helper()
+
+	op, err := putOp(tableChirps, strconv.Itoa(id), Chirp{Id: id, AuthorId: 1, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// sortedKeys returns the ids of the chirps in data in ascending order
+func sortedKeys(chirps map[int]Chirp) []int {
+	ids := []int{}
+	for id := range chirps {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestReplayJournal(t *testing.T) {
+	tests := []struct {
+		name        string
+		journal     func(t *testing.T) string
+		wantEntries int
+		wantChirps  []int
+		wantErr     bool
+	}{
+		{
+			name:        "no journal",
+			wantEntries: 0,
+			wantChirps:  []int{},
+		},
+		{
+			name: "complete entries",
+			journal: func(t *testing.T) string {
+				return journalLine(t, putChirpOp(t, 1, "a"), putChirpOp(t, 2, "b")) +
+					journalLine(t, putChirpOp(t, 3, "c"))
+			},
+			wantEntries: 2,
+			wantChirps:  []int{1, 2, 3},
+		},
+		{
+			name: "delete",
+			journal: func(t *testing.T) string {
+				return journalLine(t, putChirpOp(t, 1, "a"), putChirpOp(t, 2, "b")) +
+					journalLine(t, deleteOp(tableChirps, "1"))
+			},
+			wantEntries: 2,
+			wantChirps:  []int{2},
+		},
+		{
+			name: "torn final line",
+			journal: func(t *testing.T) string {
+				torn := journalLine(t, putChirpOp(t, 2, "b"))
+				return journalLine(t, putChirpOp(t, 1, "a")) + torn[:len(torn)/2]
+			},
+			wantEntries: 1,
+			wantChirps:  []int{1},
+		},
+		{
+			name: "torn final line is the only one",
+			journal: func(t *testing.T) string {
+				return `{"ops":[{"op":"put","table":"chirps"`
+			},
+			wantEntries: 0,
+			wantChirps:  []int{},
+		},
+		{
+			name: "blank lines",
+			journal: func(t *testing.T) string {
+				return "\n" + journalLine(t, putChirpOp(t, 1, "a")) + "\n"
+			},
+			wantEntries: 1,
+			wantChirps:  []int{1},
+		},
+		{
+			name: "corrupt complete line",
+			journal: func(t *testing.T) string {
+				return journalLine(t, putChirpOp(t, 1, "a")) + "not json\n"
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown table",
+			journal: func(t *testing.T) string {
+				return journalLine(t, deleteOp("nope", "1"))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{path: filepath.Join(t.TempDir(), "database.json")}
+			if tt.journal != nil {
+				if err := os.WriteFile(db.journalPath(), []byte(tt.journal(t)), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data := newTestData()
+			entries, err := db.replayJournal(&data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("replayJournal succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if entries != tt.wantEntries {
+				t.Errorf("replayed %d entries, want %d", entries, tt.wantEntries)
+			}
+			if got := sortedKeys(data.Chirps); !slices.Equal(got, tt.wantChirps) {
+				t.Errorf("chirps = %v, want %v", got, tt.wantChirps)
+			}
+		})
+	}
+}
+
+func TestAppendJournalReplay(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	for i := 1; i <= 3; i++ {
+		if err := db.appendJournal([]journalOp{putChirpOp(t, i, "chirp")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := newTestData()
+	entries, err := db.replayJournal(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 3 {
+		t.Errorf("replayed %d entries, want 3", entries)
+	}
+	if got := sortedKeys(data.Chirps); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("chirps = %v, want [1 2 3]", got)
+	}
+}
+
+func TestAppendAfterTornEntry(t *testing.T) {
+	tests := []struct {
+		name          string
+		encryptionKey []byte
+		journal       func(t *testing.T) string
+		wantChirps    []int
+	}{
+		{
+			name: "torn after a complete entry",
+			journal: func(t *testing.T) string {
+				torn := journalLine(t, putChirpOp(t, 2, "b"))
+				return journalLine(t, putChirpOp(t, 1, "a")) + torn[:len(torn)/2]
+			},
+			wantChirps: []int{1, 3},
+		},
+		{
+			name: "torn entry is the only one",
+			journal: func(t *testing.T) string {
+				return `{"ops":[{"op":"put","table":"chirps"`
+			},
+			wantChirps: []int{3},
+		},
+		{
+			name:          "encrypted",
+			encryptionKey: testKey(1, 32),
+			journal: func(t *testing.T) string {
+				return "enc:dG9ybg"
+			},
+			wantChirps: []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, tt.encryptionKey)
+			if err := os.WriteFile(db.journalPath(), []byte(tt.journal(t)), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			err := db.Update(func(tx *Tx) error {
+				return tx.PutChirp(Chirp{Id: 3, AuthorId: 1, Body: "after the crash"})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reopened, err := NewDB(db.path, tt.encryptionKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortedKeys(reopened.data.Chirps); !slices.Equal(got, tt.wantChirps) {
+				t.Errorf("chirps = %v, want %v", got, tt.wantChirps)
+			}
+		})
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	tests := []struct {
+		name        string
+		commits     int
+		wantEntries int
+	}{
+		{"single commit", 1, 1},
+		{"just below compactEvery", compactEvery - 1, compactEvery - 1},
+		{"at compactEvery", compactEvery, 0},
+		{"past compactEvery", compactEvery + compactEvery/2, compactEvery / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, nil)
+
+			for i := 1; i <= tt.commits; i++ {
+				if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if db.journalEntries != tt.wantEntries {
+				t.Errorf("journalEntries = %d, want %d", db.journalEntries, tt.wantEntries)
+			}
+
+			data := newTestData()
+			entries, err := db.replayJournal(&data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entries != tt.wantEntries {
+				t.Errorf("journal holds %d entries, want %d", entries, tt.wantEntries)
+			}
+
+			reopened, err := NewDB(db.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(reopened.data.Chirps); got != tt.commits {
+				t.Errorf("reopened database has %d chirps, want %d", got, tt.commits)
+			}
+		})
+	}
+}
+
+func TestCommitWithoutWrites(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	if err := db.Update(func(tx *Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(db.journalPath()); err == nil && info.Size() > 0 {
+		t.Errorf("empty transaction wrote %d bytes to the journal", info.Size())
+	}
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Like records that a user likes a chirp
+type Like struct {
+	ChirpId   int       `json:"chirp_id"`
+	UserId    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// likeKey is the key a like is stored under in the json database
+func likeKey(chirpId, userId int) string {
+	return strconv.Itoa(chirpId) + ":" + strconv.Itoa(userId)
+}
+
+// LikeChirp records that the user likes the chirp, liking it again is a no-op.
+// A user can't like the chirps of someone who blocks them.
+func (db *DB) LikeChirp(chirpId, userId int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpId)
+		if !ok || chirp.Removed() {
+			return errChirpNotFound
+		}
+
+		if err := checkBlocked(tx, chirp.AuthorId, userId); err != nil {
+			return err
+		}
+
+		if _, liked := tx.Like(chirpId, userId); liked {
+			return nil
+		}
+
+		err := tx.PutLike(Like{ChirpId: chirpId, UserId: userId, CreatedAt: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+
+		chirp.LikeCount++
+		return tx.PutChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.LikedByMe = true
+	return chirp, nil
+}
+
+// UnlikeChirp removes the user's like from the chirp, if there is one
+func (db *DB) UnlikeChirp(chirpId, userId int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpId)
+		if !ok || chirp.Removed() {
+			return errChirpNotFound
+		}
+
+		if _, liked := tx.Like(chirpId, userId); !liked {
+			return nil
+		}
+
+		if err := tx.DeleteLike(chirpId, userId); err != nil {
+			return err
+		}
+
+		chirp.LikeCount--
+		return tx.PutChirp(chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// GetLikedChirps returns the chirps a user likes, most recently liked first
+func (db *DB) GetLikedChirps(userId int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(tx *Tx) error {
+		likes := []Like{}
+		for _, chirpId := range tx.LikesBy(userId) {
+			like, _ := tx.Like(chirpId, userId)
+			likes = append(likes, like)
+		}
+		sortLikes(likes)
+
+		for _, like := range likes {
+			chirp, _ := tx.Chirp(like.ChirpId)
+			chirps = append(chirps, chirp)
+		}
+		return nil
+	})
+
+	return chirps, err
+}
+
+// GetLikedChirpIds reports which of chirpIds the user likes
+func (db *DB) GetLikedChirpIds(userId int, chirpIds []int) (map[int]bool, error) {
+	liked := map[int]bool{}
+	err := db.View(func(tx *Tx) error {
+		for _, chirpId := range chirpIds {
+			if _, ok := tx.Like(chirpId, userId); ok {
+				liked[chirpId] = true
+			}
+		}
+		return nil
+	})
+
+	return liked, err
+}
+
+// sortLikes orders likes newest first
+func sortLikes(likes []Like) {
+	slices.SortFunc(likes, func(a, b Like) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return b.ChirpId - a.ChirpId
+	})
+}
+
+// LikeChirp records that the user likes the chirp, liking it again is a no-op.
+// A user can't like the chirps of someone who blocks them.
+func (db *SQLiteDB) LikeChirp(chirpId, userId int) (Chirp, error) {
+	return db.updateLike(chirpId, true, func(tx *sql.Tx) (int, error) {
+		var authorId int
+		if err := tx.QueryRow(`SELECT author_id FROM chirps WHERE id = ?`, chirpId).Scan(&authorId); err != nil {
+			return 0, err
+		}
+		if err := checkSQLiteBlocked(tx, authorId, userId); err != nil {
+			return 0, err
+		}
+
+		res, err := tx.Exec(`
+			INSERT INTO chirp_likes (chirp_id, user_id, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (chirp_id, user_id) DO NOTHING`,
+			chirpId, userId, time.Now().UTC())
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := res.RowsAffected()
+		return int(n), err
+	})
+}
+
+// UnlikeChirp removes the user's like from the chirp, if there is one
+func (db *SQLiteDB) UnlikeChirp(chirpId, userId int) (Chirp, error) {
+	return db.updateLike(chirpId, false, func(tx *sql.Tx) (int, error) {
+		res, err := tx.Exec(`DELETE FROM chirp_likes WHERE chirp_id = ? AND user_id = ?`, chirpId, userId)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := res.RowsAffected()
+		return -int(n), err
+	})
+}
+
+// updateLike runs change, which returns how it moved the like count,
+// and applies that to the chirp in the same transaction
+func (db *SQLiteDB) updateLike(chirpId int, likedByMe bool, change func(tx *sql.Tx) (int, error)) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	err = tx.QueryRow(`SELECT deleted OR deleted_at IS NOT NULL FROM chirps WHERE id = ?`, chirpId).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) || deleted {
+		return Chirp{}, errChirpNotFound
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	delta, err := change(tx)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE chirps SET like_count = like_count + ? WHERE id = ?`, delta, chirpId); err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpId))
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.LikedByMe = likedByMe
+
+	return chirp, tx.Commit()
+}
+
+// GetLikedChirps returns the chirps a user likes, most recently liked first
+func (db *SQLiteDB) GetLikedChirps(userId int) ([]Chirp, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+qualifiedChirpColumns+` FROM chirp_likes
+		JOIN chirps ON chirps.id = chirp_likes.chirp_id
+		WHERE chirp_likes.user_id = ?
+		ORDER BY chirp_likes.created_at DESC, chirp_likes.chirp_id DESC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, rows.Err()
+}
+
+// GetLikedChirpIds reports which of chirpIds the user likes
+func (db *SQLiteDB) GetLikedChirpIds(userId int, chirpIds []int) (map[int]bool, error) {
+	liked := map[int]bool{}
+	if len(chirpIds) == 0 {
+		return liked, nil
+	}
+
+	args := []any{userId}
+	for _, chirpId := range chirpIds {
+		args = append(args, chirpId)
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT chirp_id FROM chirp_likes
+		WHERE user_id = ? AND chirp_id IN (?`+strings.Repeat(", ?", len(chirpIds)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chirpId int
+		if err := rows.Scan(&chirpId); err != nil {
+			return nil, err
+		}
+		liked[chirpId] = true
+	}
+
+	return liked, rows.Err()
+}
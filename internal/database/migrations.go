@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Migration describes a single schema migration
+type Migration struct {
+	Version int
+	Name    string
+}
+
+type jsonMigration struct {
+	Migration
+	up func(data *DBStructure) error
+}
+
+// jsonMigrations are run in order by NewDB, DBStructure.Version records
+// the last one applied. Append new migrations, never reorder or edit old ones.
+var jsonMigrations = []jsonMigration{
+	{
+		Migration: Migration{Version: 1, Name: "allocate ids from per-table sequences"},
+		up: func(data *DBStructure) error {
+			// files written before sequences existed allocated ids as len(map)+1,
+			// which reused the ids of deleted records
+			for id := range data.Chirps {
+				data.Sequences[tableChirps] = max(data.Sequences[tableChirps], id)
+			}
+			for id := range data.Users {
+				data.Sequences[tableUsers] = max(data.Sequences[tableUsers], id)
+			}
+			return nil
+		},
+	},
+	{
+		Migration: Migration{Version: 2, Name: "hash revoked tokens and record their expiry"},
+		up: func(data *DBStructure) error {
+			tokens := make(map[string]RevokedToken, len(data.RevokedTokens))
+			for token, revoked := range data.RevokedTokens {
+				// the expiry of tokens revoked before it was recorded is unknown,
+				// they can't outlive a refresh token issued when they were revoked
+				revoked.ExpiresAt = revoked.RevokedAt.Add(legacyRefreshTokenLifetime)
+				tokens[hashToken(token)] = revoked
+			}
+			data.RevokedTokens = tokens
+			return nil
+		},
+	},
+	{
+		Migration: Migration{Version: 3, Name: "add created_at and updated_at to chirps and users"},
+		up: func(data *DBStructure) error {
+			// when existing records were created is unknown,
+			// they are stamped with the time of the migration
+			now := time.Now().UTC()
+			for id, chirp := range data.Chirps {
+				if chirp.CreatedAt.IsZero() {
+					chirp.CreatedAt, chirp.UpdatedAt = now, now
+					data.Chirps[id] = chirp
+				}
+			}
+			for id, user := range data.Users {
+				if user.CreatedAt.IsZero() {
+					user.CreatedAt, user.UpdatedAt = now, now
+					data.Users[id] = user
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Migration: Migration{Version: 4, Name: "add hashtags to existing chirps"},
+		up: func(data *DBStructure) error {
+			// nobody had a username yet, so existing chirps only get their hashtags
+			for id, chirp := range data.Chirps {
+				if !chirp.Deleted {
+					chirp.Entities = ChirpEntities{Hashtags: parseEntities(chirp.Body).Hashtags}
+					data.Chirps[id] = chirp
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// legacyRefreshTokenLifetime is the lifetime of refresh tokens issued by auth.GetRefreshToken
+const legacyRefreshTokenLifetime = 60 * 24 * time.Hour
+
+func latestJSONVersion() int {
+	return jsonMigrations[len(jsonMigrations)-1].Version
+}
+
+// checkVersion refuses to open databases written by a newer binary
+func checkVersion(version, latest int) error {
+	if version > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d", version, latest)
+	}
+	return nil
+}
+
+// PendingMigrations returns the migrations that haven't been applied yet
+func (db *DB) PendingMigrations() ([]Migration, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.pendingMigrations()
+}
+
+func (db *DB) pendingMigrations() ([]Migration, error) {
+	if err := checkVersion(db.data.Version, latestJSONVersion()); err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, m := range jsonMigrations {
+		if m.Version > db.data.Version {
+			pending = append(pending, m.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations and writes a new snapshot.
+// If a migration fails the database is reloaded from disk untouched.
+func (db *DB) Migrate() ([]Migration, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	pending, err := db.pendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return pending, err
+	}
+
+	for _, m := range jsonMigrations {
+		if m.Version <= db.data.Version {
+			continue
+		}
+
+		if err := m.up(&db.data); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err),
+				db.loadDB(),
+			)
+		}
+		db.data.Version = m.Version
+	}
+	db.data.buildIndexes()
+
+	if err := db.writeDB(); err != nil {
+		return nil, errors.Join(err, db.loadDB())
+	}
+
+	return pending, nil
+}
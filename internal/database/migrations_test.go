@@ -0,0 +1,233 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationRegistries(t *testing.T) {
+	jsonRegistry := []Migration{}
+	for _, m := range jsonMigrations {
+		jsonRegistry = append(jsonRegistry, m.Migration)
+	}
+	sqliteRegistry := []Migration{}
+	for _, m := range sqliteMigrations {
+		sqliteRegistry = append(sqliteRegistry, m.Migration)
+	}
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		latest     int
+	}{
+		{"json", jsonRegistry, latestJSONVersion()},
+		{"sqlite", sqliteRegistry, latestSQLiteVersion()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, m := range tt.migrations {
+				if m.Version != i+1 {
+					t.Errorf("migration %d has version %d, want %d", i, m.Version, i+1)
+				}
+				if m.Name == "" {
+					t.Errorf("migration %d has no name", m.Version)
+				}
+			}
+
+			if tt.latest != len(tt.migrations) {
+				t.Errorf("latest version = %d, want %d", tt.latest, len(tt.migrations))
+			}
+		})
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		wantErr bool
+	}{
+		{"new database", 0, false},
+		{"older", 1, false},
+		{"latest", 4, false},
+		{"newer", 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkVersion(tt.version, 4); (err != nil) != tt.wantErr {
+				t.Errorf("checkVersion(%d, 4) error = %v, want error %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// legacySnapshot is a database.json written before any migration existed
+const legacySnapshot = `{
+	"chirps": {"3": {"id": 3, "author_id": 1, "body": "hello #Go"}},
+	"users": {"1": {"id": 1, "email": "a@example.com", "password": "x"}},
+	"revoked_tokens": {"raw-token": {"revoked_at": "2024-01-01T00:00:00Z"}}
+}`
+
+// diskState is the content and stamp of the database files
+type diskState struct {
+	snapshot []byte
+	journal  []byte
+	stamp    fileStamp
+}
+
+func readDiskState(t *testing.T, db *DB) diskState {
+	t.Helper()
+
+	snapshot, err := os.ReadFile(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := os.ReadFile(db.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	stamp, err := db.currentStamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return diskState{snapshot: snapshot, journal: journal, stamp: stamp}
+}
+
+func (s diskState) equal(other diskState) bool {
+	return bytes.Equal(s.snapshot, other.snapshot) && bytes.Equal(s.journal, other.journal) && s.stamp == other.stamp
+}
+
+func TestMigrateJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	if err := os.WriteFile(path, []byte(legacySnapshot), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := readDiskState(t, db)
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(jsonMigrations) {
+		t.Errorf("%d pending migrations, want %d", len(pending), len(jsonMigrations))
+	}
+	if !readDiskState(t, db).equal(before) {
+		t.Error("opening the database rewrote its files")
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(jsonMigrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(jsonMigrations))
+	}
+	migrated := readDiskState(t, db)
+	if migrated.equal(before) {
+		t.Error("migrating left the database files untouched")
+	}
+
+	reopened, err := openDB(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := reopened.data
+
+	if _, ok := data.RevokedTokens[hashToken("raw-token")]; !ok || len(data.RevokedTokens) != 1 {
+		t.Errorf("revoked tokens = %v, want only the hash of raw-token", data.RevokedTokens)
+	}
+	if data.Version != latestJSONVersion() {
+		t.Errorf("version = %d, want %d", data.Version, latestJSONVersion())
+	}
+	if data.Sequences[tableChirps] != 3 || data.Sequences[tableUsers] != 1 {
+		t.Errorf("sequences = %v, want chirps 3 and users 1", data.Sequences)
+	}
+	if data.Chirps[3].CreatedAt.IsZero() || data.Users[1].CreatedAt.IsZero() {
+		t.Error("existing records weren't given a created_at")
+	}
+	if !data.Chirps[3].Entities.hasHashtag("go") {
+		t.Errorf("existing chirp entities = %+v, want the hashtag go", data.Chirps[3].Entities)
+	}
+
+	applied, err = reopened.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("migrating again applied %d migrations", len(applied))
+	}
+	if !readDiskState(t, reopened).equal(migrated) {
+		t.Error("migrating an up to date database rewrote its files")
+	}
+}
+
+func TestOpenNewerJSONDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	if err := os.WriteFile(path, []byte(`{"version": 1000}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openDB(path, nil); err == nil {
+		t.Error("opened a database written by a newer version")
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.db")
+
+	db, err := openSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(sqliteMigrations) {
+		t.Errorf("%d pending migrations, want %d", len(pending), len(sqliteMigrations))
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(sqliteMigrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(sqliteMigrations))
+	}
+
+	version, err := db.schemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSQLiteVersion() {
+		t.Errorf("user_version = %d, want %d", version, latestSQLiteVersion())
+	}
+
+	applied, err = db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("migrating again applied %d migrations", len(applied))
+	}
+
+	if _, err := db.conn.Exec(`PRAGMA user_version = 1000`); err != nil {
+		t.Fatal(err)
+	}
+	if newer, err := openSQLiteDB(path); err == nil {
+		newer.Close()
+		t.Error("opened a database written by a newer version")
+	}
+}
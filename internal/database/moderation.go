@@ -0,0 +1,306 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Moderation statuses, a flagged chirp is pending until an admin
+// approves, hides or deletes it
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationHidden   = "hidden"
+	ModerationDeleted  = "deleted"
+)
+
+var (
+	ErrNotInModerationQueue = errors.New("Chirp is not in the moderation queue")
+	ErrUnknownDecision      = errors.New("Unknown moderation decision")
+)
+
+// ModerationItem is a chirp in the moderation queue. Reasons are why it was
+// flagged, FlaggedAt when it was last flagged. Once reviewed, DecidedAt and
+// Note record the decision. The item outlives a chirp deleted by an admin.
+type ModerationItem struct {
+	ChirpId   int        `json:"chirp_id"`
+	Status    string     `json:"status"`
+	Reasons   []string   `json:"reasons"`
+	FlaggedAt time.Time  `json:"flagged_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+}
+
+// flag puts an item back in the queue with reasons added to those it already has
+func (item ModerationItem) flag(reasons []string) ModerationItem {
+	for _, reason := range reasons {
+		if !slices.Contains(item.Reasons, reason) {
+			item.Reasons = append(item.Reasons, reason)
+		}
+	}
+
+	item.Status = ModerationPending
+	item.FlaggedAt = time.Now().UTC()
+	item.DecidedAt = nil
+	item.Note = ""
+	return item
+}
+
+// decide records a review decision on an item
+func (item ModerationItem) decide(status, note string) ModerationItem {
+	now := time.Now().UTC()
+	item.Status = status
+	item.DecidedAt = &now
+	item.Note = note
+	return item
+}
+
+// decisionStatus maps an admin decision to the status it leaves the item in
+func decisionStatus(decision string) (string, error) {
+	switch decision {
+	case "approve":
+		return ModerationApproved, nil
+	case "hide":
+		return ModerationHidden, nil
+	case "delete":
+		return ModerationDeleted, nil
+	default:
+		return "", ErrUnknownDecision
+	}
+}
+
+// sortModerationQueue orders items by when they were flagged, oldest first
+func sortModerationQueue(items []ModerationItem) {
+	slices.SortFunc(items, func(a, b ModerationItem) int {
+		if c := a.FlaggedAt.Compare(b.FlaggedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ChirpId, b.ChirpId)
+	})
+}
+
+// FlagChirp puts a chirp in the moderation queue for review, or back in it
+// if it was reviewed before, without changing whether it is hidden
+func (db *DB) FlagChirp(chirpId int, reasons []string) (ModerationItem, error) {
+	var item ModerationItem
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		item, err = flagChirp(tx, chirpId, reasons)
+		return err
+	})
+	if err != nil {
+		return ModerationItem{}, err
+	}
+
+	return item, nil
+}
+
+func flagChirp(tx *Tx, chirpId int, reasons []string) (ModerationItem, error) {
+	chirp, ok := tx.Chirp(chirpId)
+	if !ok || chirp.Removed() {
+		return ModerationItem{}, errChirpNotFound
+	}
+
+	item, _ := tx.ModerationItem(chirpId)
+	item.ChirpId = chirpId
+	item = item.flag(reasons)
+	return item, tx.PutModerationItem(item)
+}
+
+// GetModerationQueue returns the items with a status, or every item
+// for an empty status, oldest flag first
+func (db *DB) GetModerationQueue(status string) ([]ModerationItem, error) {
+	items := []ModerationItem{}
+	err := db.View(func(tx *Tx) error {
+		for _, item := range tx.data.Moderation {
+			if status == "" || item.Status == status {
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortModerationQueue(items)
+	return items, nil
+}
+
+// ReviewChirp records an admin decision on a chirp in the moderation queue:
+// approve shows it again, hide hides it from everyone but its author and
+// delete deletes it like its author would
+func (db *DB) ReviewChirp(chirpId int, decision, note string) (ModerationItem, error) {
+	status, err := decisionStatus(decision)
+	if err != nil {
+		return ModerationItem{}, err
+	}
+
+	var item ModerationItem
+	err = db.Update(func(tx *Tx) error {
+		var ok bool
+		item, ok = tx.ModerationItem(chirpId)
+		if !ok || item.Status == ModerationDeleted {
+			return ErrNotInModerationQueue
+		}
+
+		if status == ModerationDeleted {
+			if err := deleteChirp(tx, chirpId); err != nil {
+				return err
+			}
+		} else {
+			chirp, _ := tx.Chirp(chirpId)
+			chirp.Hidden = status == ModerationHidden
+			if err := tx.PutChirp(chirp); err != nil {
+				return err
+			}
+		}
+
+		item = item.decide(status, note)
+		return tx.PutModerationItem(item)
+	})
+	if err != nil {
+		return ModerationItem{}, err
+	}
+
+	return item, nil
+}
+
+// FlagChirp puts a chirp in the moderation queue for review, or back in it
+// if it was reviewed before, without changing whether it is hidden
+func (db *SQLiteDB) FlagChirp(chirpId int, reasons []string) (ModerationItem, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return ModerationItem{}, err
+	}
+	defer tx.Rollback()
+
+	item, err := flagSQLiteChirp(tx, chirpId, reasons)
+	if err != nil {
+		return ModerationItem{}, err
+	}
+
+	return item, tx.Commit()
+}
+
+func flagSQLiteChirp(tx *sql.Tx, chirpId int, reasons []string) (ModerationItem, error) {
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND NOT deleted AND deleted_at IS NULL)`, chirpId).
+		Scan(&exists); err != nil {
+		return ModerationItem{}, err
+	}
+	if !exists {
+		return ModerationItem{}, errChirpNotFound
+	}
+
+	item, err := scanModerationItem(tx.QueryRow(`SELECT `+moderationColumns+` FROM moderation_queue WHERE chirp_id = ?`, chirpId))
+	if err != nil && !errors.Is(err, ErrNotInModerationQueue) {
+		return ModerationItem{}, err
+	}
+
+	item.ChirpId = chirpId
+	item = item.flag(reasons)
+	return item, putSQLiteModerationItem(tx, item)
+}
+
+// GetModerationQueue returns the items with a status, or every item
+// for an empty status, oldest flag first
+func (db *SQLiteDB) GetModerationQueue(status string) ([]ModerationItem, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+moderationColumns+` FROM moderation_queue
+		WHERE ? = '' OR status = ?`, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []ModerationItem{}
+	for rows.Next() {
+		item, err := scanModerationItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortModerationQueue(items)
+	return items, nil
+}
+
+// ReviewChirp records an admin decision on a chirp in the moderation queue:
+// approve shows it again, hide hides it from everyone but its author and
+// delete deletes it like its author would
+func (db *SQLiteDB) ReviewChirp(chirpId int, decision, note string) (ModerationItem, error) {
+	status, err := decisionStatus(decision)
+	if err != nil {
+		return ModerationItem{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return ModerationItem{}, err
+	}
+	defer tx.Rollback()
+
+	item, err := scanModerationItem(tx.QueryRow(`SELECT `+moderationColumns+` FROM moderation_queue WHERE chirp_id = ?`, chirpId))
+	if err != nil {
+		return ModerationItem{}, err
+	}
+	if item.Status == ModerationDeleted {
+		return ModerationItem{}, ErrNotInModerationQueue
+	}
+
+	if status == ModerationDeleted {
+		if err := deleteSQLiteChirp(tx, chirpId); err != nil {
+			return ModerationItem{}, err
+		}
+	} else if _, err := tx.Exec(`UPDATE chirps SET hidden = ? WHERE id = ?`, status == ModerationHidden, chirpId); err != nil {
+		return ModerationItem{}, err
+	}
+
+	item = item.decide(status, note)
+	if err := putSQLiteModerationItem(tx, item); err != nil {
+		return ModerationItem{}, err
+	}
+
+	return item, tx.Commit()
+}
+
+const moderationColumns = `chirp_id, status, reasons, flagged_at, decided_at, note`
+
+// scanModerationItem reads a single moderation_queue row selected with
+// moderationColumns, mapping a missing row to ErrNotInModerationQueue
+func scanModerationItem(row rowScanner) (ModerationItem, error) {
+	var item ModerationItem
+	var reasons string
+	err := row.Scan(&item.ChirpId, &item.Status, &reasons, &item.FlaggedAt, &item.DecidedAt, &item.Note)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ModerationItem{}, ErrNotInModerationQueue
+	}
+	if err != nil {
+		return ModerationItem{}, err
+	}
+
+	return item, json.Unmarshal([]byte(reasons), &item.Reasons)
+}
+
+func putSQLiteModerationItem(tx *sql.Tx, item ModerationItem) error {
+	reasons, err := json.Marshal(item.Reasons)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO moderation_queue (`+moderationColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chirp_id) DO UPDATE SET status = excluded.status, reasons = excluded.reasons,
+			flagged_at = excluded.flagged_at, decided_at = excluded.decided_at, note = excluded.note`,
+		item.ChirpId, item.Status, string(reasons), item.FlaggedAt, item.DecidedAt, item.Note)
+	return err
+}
@@ -0,0 +1,439 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Report statuses, a report is open until an admin resolves or dismisses it
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// ReportReasons are the categories a report can be filed under
+var ReportReasons = []string{"spam", "harassment", "hate", "violence", "misinformation", "other"}
+
+// reportFlagThreshold is the number of open reports from different users
+// that flags a chirp for review or a user as flagged
+const reportFlagThreshold = 3
+
+var (
+	ErrReportNotFound = errors.New("Report not found")
+	ErrReportClosed   = errors.New("Report is already closed")
+)
+
+// Report is a user's report of a chirp or of another user, exactly one
+// of ChirpId and UserId is set. ClosedAt and Note record how an admin
+// resolved or dismissed it. A report outlives the chirp it is about.
+type Report struct {
+	Id         int        `json:"id"`
+	ReporterId int        `json:"reporter_id"`
+	ChirpId    int        `json:"chirp_id,omitempty"`
+	UserId     int        `json:"user_id,omitempty"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ClosedAt   *time.Time `json:"closed_at,omitempty"`
+	Note       string     `json:"note,omitempty"`
+}
+
+// NewReport is a report to be filed by CreateReport, about ChirpId or UserId
+type NewReport struct {
+	ReporterId int
+	ChirpId    int
+	UserId     int
+	Reason     string
+	Details    string
+}
+
+// ReportQuery filters GetReports, zero values match every report
+type ReportQuery struct {
+	Status  string
+	ChirpId int
+	UserId  int
+}
+
+func (q ReportQuery) matches(report Report) bool {
+	return (q.Status == "" || report.Status == q.Status) &&
+		(q.ChirpId == 0 || report.ChirpId == q.ChirpId) &&
+		(q.UserId == 0 || report.UserId == q.UserId)
+}
+
+// closeDecisionStatus maps an admin decision on a report to the status it leaves it in
+func closeDecisionStatus(decision string) (string, error) {
+	switch decision {
+	case "resolve":
+		return ReportResolved, nil
+	case "dismiss":
+		return ReportDismissed, nil
+	default:
+		return "", ErrUnknownDecision
+	}
+}
+
+// reportFlagReasons are the moderation reasons for a chirp with these open reports
+func reportFlagReasons(reports []Report) []string {
+	reasons := []string{}
+	for _, report := range reports {
+		reason := fmt.Sprintf("report: %s", report.Reason)
+		if !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
+
+// CreateReport files a report. A reporter with an open report about the same
+// chirp or user gets that report back instead. Once enough users have open
+// reports about it, a chirp is flagged for review and a user is flagged.
+func (db *DB) CreateReport(newReport NewReport) (Report, error) {
+	var report Report
+	err := db.Update(func(tx *Tx) error {
+		open := openReports(tx, newReport.ChirpId, newReport.UserId)
+		for _, existing := range open {
+			if existing.ReporterId == newReport.ReporterId {
+				report = existing
+				return nil
+			}
+		}
+
+		id, err := tx.nextId(tableReports)
+		if err != nil {
+			return err
+		}
+
+		report = Report{
+			Id:         id,
+			ReporterId: newReport.ReporterId,
+			ChirpId:    newReport.ChirpId,
+			UserId:     newReport.UserId,
+			Reason:     newReport.Reason,
+			Details:    newReport.Details,
+			Status:     ReportOpen,
+			CreatedAt:  time.Now().UTC(),
+		}
+		if err := tx.PutReport(report); err != nil {
+			return err
+		}
+
+		open = append(open, report)
+		if len(open) < reportFlagThreshold {
+			return nil
+		}
+
+		if report.ChirpId != 0 {
+			_, err := flagChirp(tx, report.ChirpId, reportFlagReasons(open))
+			return err
+		}
+		return setUserFlagged(tx, report.UserId, true)
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// openReports returns the open reports about a chirp, or a user for chirpId 0
+func openReports(tx *Tx, chirpId, userId int) []Report {
+	ids := tx.data.idx.reportsOfUser[userId]
+	if chirpId != 0 {
+		ids = tx.data.idx.reportsOfChirp[chirpId]
+	}
+
+	reports := []Report{}
+	for _, id := range ids {
+		if report := tx.data.Reports[id]; report.Status == ReportOpen {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+func setUserFlagged(tx *Tx, userId int, flagged bool) error {
+	user, ok := tx.User(userId)
+	if !ok || user.Flagged == flagged {
+		return nil
+	}
+
+	user.Flagged = flagged
+	return tx.PutUser(user)
+}
+
+// GetReports returns the reports matching query, oldest first
+func (db *DB) GetReports(query ReportQuery) ([]Report, error) {
+	reports := []Report{}
+	err := db.View(func(tx *Tx) error {
+		for _, report := range tx.data.Reports {
+			if query.matches(report) {
+				reports = append(reports, report)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(reports, func(a, b Report) int {
+		return a.Id - b.Id
+	})
+	return reports, nil
+}
+
+// CloseReport records an admin decision on an open report, resolve or dismiss.
+// A user stops being flagged once too few open reports about them are left.
+func (db *DB) CloseReport(reportId int, decision, note string) (Report, error) {
+	status, err := closeDecisionStatus(decision)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	err = db.Update(func(tx *Tx) error {
+		var ok bool
+		report, ok = tx.Report(reportId)
+		if !ok {
+			return ErrReportNotFound
+		}
+		if report.Status != ReportOpen {
+			return ErrReportClosed
+		}
+
+		now := time.Now().UTC()
+		report.Status = status
+		report.ClosedAt = &now
+		report.Note = note
+		if err := tx.PutReport(report); err != nil {
+			return err
+		}
+
+		if report.UserId != 0 && len(openReports(tx, 0, report.UserId)) < reportFlagThreshold {
+			return setUserFlagged(tx, report.UserId, false)
+		}
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// GetFlaggedUsers returns the users flagged by reports in ascending id order
+func (db *DB) GetFlaggedUsers() ([]User, error) {
+	users := []User{}
+	err := db.View(func(tx *Tx) error {
+		for _, user := range tx.data.Users {
+			if user.Flagged {
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(users, func(a, b User) int {
+		return a.Id - b.Id
+	})
+	return users, nil
+}
+
+// CreateReport files a report. A reporter with an open report about the same
+// chirp or user gets that report back instead. Once enough users have open
+// reports about it, a chirp is flagged for review and a user is flagged.
+func (db *SQLiteDB) CreateReport(newReport NewReport) (Report, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Report{}, err
+	}
+	defer tx.Rollback()
+
+	open, err := openSQLiteReports(tx, newReport.ChirpId, newReport.UserId)
+	if err != nil {
+		return Report{}, err
+	}
+	for _, existing := range open {
+		if existing.ReporterId == newReport.ReporterId {
+			return existing, tx.Commit()
+		}
+	}
+
+	report := Report{
+		ReporterId: newReport.ReporterId,
+		ChirpId:    newReport.ChirpId,
+		UserId:     newReport.UserId,
+		Reason:     newReport.Reason,
+		Details:    newReport.Details,
+		Status:     ReportOpen,
+		CreatedAt:  time.Now().UTC(),
+	}
+	res, err := tx.Exec(`
+		INSERT INTO reports (reporter_id, chirp_id, user_id, reason, details, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		report.ReporterId, nullId(report.ChirpId), nullId(report.UserId), report.Reason, report.Details,
+		report.Status, report.CreatedAt)
+	if err != nil {
+		return Report{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Report{}, err
+	}
+	report.Id = int(id)
+
+	open = append(open, report)
+	if len(open) >= reportFlagThreshold {
+		if report.ChirpId != 0 {
+			_, err = flagSQLiteChirp(tx, report.ChirpId, reportFlagReasons(open))
+		} else {
+			_, err = tx.Exec(`UPDATE users SET flagged = TRUE WHERE id = ?`, report.UserId)
+		}
+		if err != nil {
+			return Report{}, err
+		}
+	}
+
+	return report, tx.Commit()
+}
+
+// openSQLiteReports returns the open reports about a chirp, or a user for chirpId 0
+func openSQLiteReports(tx *sql.Tx, chirpId, userId int) ([]Report, error) {
+	query := `SELECT ` + reportColumns + ` FROM reports WHERE user_id = ? AND status = ? ORDER BY id`
+	args := []any{userId, ReportOpen}
+	if chirpId != 0 {
+		query = `SELECT ` + reportColumns + ` FROM reports WHERE chirp_id = ? AND status = ? ORDER BY id`
+		args[0] = chirpId
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanReports(rows)
+}
+
+// GetReports returns the reports matching query, oldest first
+func (db *SQLiteDB) GetReports(query ReportQuery) ([]Report, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+reportColumns+` FROM reports
+		WHERE (? = '' OR status = ?)
+		AND (? = 0 OR chirp_id = ?)
+		AND (? = 0 OR user_id = ?)
+		ORDER BY id`,
+		query.Status, query.Status, query.ChirpId, query.ChirpId, query.UserId, query.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanReports(rows)
+}
+
+// CloseReport records an admin decision on an open report, resolve or dismiss.
+// A user stops being flagged once too few open reports about them are left.
+func (db *SQLiteDB) CloseReport(reportId int, decision, note string) (Report, error) {
+	status, err := closeDecisionStatus(decision)
+	if err != nil {
+		return Report{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Report{}, err
+	}
+	defer tx.Rollback()
+
+	report, err := scanReport(tx.QueryRow(`SELECT `+reportColumns+` FROM reports WHERE id = ?`, reportId))
+	if err != nil {
+		return Report{}, err
+	}
+	if report.Status != ReportOpen {
+		return Report{}, ErrReportClosed
+	}
+
+	now := time.Now().UTC()
+	report.Status = status
+	report.ClosedAt = &now
+	report.Note = note
+	if _, err := tx.Exec(`UPDATE reports SET status = ?, closed_at = ?, note = ? WHERE id = ?`,
+		report.Status, report.ClosedAt, report.Note, reportId); err != nil {
+		return Report{}, err
+	}
+
+	if report.UserId != 0 {
+		if _, err := tx.Exec(`
+			UPDATE users SET flagged = FALSE
+			WHERE id = ? AND (SELECT COUNT(*) FROM reports WHERE user_id = ? AND status = ?) < ?`,
+			report.UserId, report.UserId, ReportOpen, reportFlagThreshold); err != nil {
+			return Report{}, err
+		}
+	}
+
+	return report, tx.Commit()
+}
+
+// GetFlaggedUsers returns the users flagged by reports in ascending id order
+func (db *SQLiteDB) GetFlaggedUsers() ([]User, error) {
+	rows, err := db.conn.Query(`SELECT ` + userColumns + ` FROM users WHERE flagged ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+const reportColumns = `id, reporter_id, chirp_id, user_id, reason, details, status, created_at, closed_at, note`
+
+// scanReport reads a single reports row selected with reportColumns,
+// mapping a missing row to ErrReportNotFound
+func scanReport(row rowScanner) (Report, error) {
+	var report Report
+	var chirpId, userId sql.NullInt64
+	err := row.Scan(&report.Id, &report.ReporterId, &chirpId, &userId, &report.Reason, &report.Details,
+		&report.Status, &report.CreatedAt, &report.ClosedAt, &report.Note)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, ErrReportNotFound
+	}
+	if err != nil {
+		return Report{}, err
+	}
+
+	report.ChirpId = int(chirpId.Int64)
+	report.UserId = int(userId.Int64)
+	return report, nil
+}
+
+func scanReports(rows *sql.Rows) ([]Report, error) {
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
@@ -0,0 +1,383 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+var ErrScheduledChirpNotFound = errors.New("Scheduled chirp not found")
+
+// ScheduledChirp is a chirp waiting to be published at PublishAt, only its
+// author sees it until then. It becomes a new chirp when it is published,
+// FlagReasons put that chirp in the moderation queue.
+type ScheduledChirp struct {
+	Id          int       `json:"id"`
+	AuthorId    int       `json:"author_id"`
+	Body        string    `json:"body"`
+	InReplyToId int       `json:"in_reply_to_id,omitempty"`
+	RechirpOfId int       `json:"rechirp_of_id,omitempty"`
+	QuoteOfId   int       `json:"quote_of_id,omitempty"`
+	FlagReasons []string  `json:"flag_reasons,omitempty"`
+	PublishAt   time.Time `json:"publish_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (scheduled ScheduledChirp) newChirp() NewChirp {
+	return NewChirp{
+		AuthorId:    scheduled.AuthorId,
+		Body:        scheduled.Body,
+		InReplyToId: scheduled.InReplyToId,
+		RechirpOfId: scheduled.RechirpOfId,
+		QuoteOfId:   scheduled.QuoteOfId,
+		FlagReasons: scheduled.FlagReasons,
+	}
+}
+
+func newScheduledChirp(chirp NewChirp, publishAt time.Time) ScheduledChirp {
+	now := time.Now().UTC()
+	return ScheduledChirp{
+		AuthorId:    chirp.AuthorId,
+		Body:        chirp.Body,
+		InReplyToId: chirp.InReplyToId,
+		RechirpOfId: chirp.RechirpOfId,
+		QuoteOfId:   chirp.QuoteOfId,
+		FlagReasons: chirp.FlagReasons,
+		PublishAt:   publishAt.UTC(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// publishDue publishes the due scheduled chirps one at a time and returns how many were
+// published. One that can't be published any more, because a chirp it refers to is
+// gone or its author is blocked, is dropped.
+func publishDue(ids []int, publish, drop func(id int) error) (int, error) {
+	published := 0
+	for _, id := range ids {
+		err := publish(id)
+		if errors.Is(err, ErrScheduledChirpNotFound) {
+			continue
+		}
+		if errors.Is(err, errChirpNotFound) || errors.Is(err, ErrBlocked) {
+			slog.Warn("DATABASE - Dropping scheduled chirp that can't be published", "scheduled_chirp_id", id, "error", err)
+			if err := drop(id); err != nil && !errors.Is(err, ErrScheduledChirpNotFound) {
+				return published, err
+			}
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// ScheduleChirp stores a chirp to be published at publishAt
+func (db *DB) ScheduleChirp(chirp NewChirp, publishAt time.Time) (ScheduledChirp, error) {
+	scheduled := newScheduledChirp(chirp, publishAt)
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		scheduled.Id, err = tx.nextId(tableScheduled)
+		if err != nil {
+			return err
+		}
+
+		return tx.PutScheduledChirp(scheduled)
+	})
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, nil
+}
+
+// GetScheduledChirps returns an author's scheduled chirps, the next to be published first
+func (db *DB) GetScheduledChirps(authorId int) ([]ScheduledChirp, error) {
+	chirps := []ScheduledChirp{}
+	err := db.View(func(tx *Tx) error {
+		for _, scheduled := range tx.data.Scheduled {
+			if scheduled.AuthorId == authorId {
+				chirps = append(chirps, scheduled)
+			}
+		}
+		return nil
+	})
+
+	slices.SortFunc(chirps, compareScheduled)
+	return chirps, err
+}
+
+func compareScheduled(a, b ScheduledChirp) int {
+	if c := a.PublishAt.Compare(b.PublishAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Id, b.Id)
+}
+
+// GetScheduledChirpById returns a scheduled chirp, or one with a zero Id if there is none
+func (db *DB) GetScheduledChirpById(id int) (ScheduledChirp, error) {
+	var scheduled ScheduledChirp
+	err := db.View(func(tx *Tx) error {
+		scheduled, _ = tx.ScheduledChirp(id)
+		return nil
+	})
+
+	return scheduled, err
+}
+
+// UpdateScheduledChirp replaces the body, flag reasons and publish time of a scheduled chirp
+func (db *DB) UpdateScheduledChirp(id int, body string, flagReasons []string, publishAt time.Time) (ScheduledChirp, error) {
+	var scheduled ScheduledChirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		scheduled, ok = tx.ScheduledChirp(id)
+		if !ok {
+			return ErrScheduledChirpNotFound
+		}
+
+		scheduled.Body = body
+		scheduled.FlagReasons = flagReasons
+		scheduled.PublishAt = publishAt.UTC()
+		scheduled.UpdatedAt = time.Now().UTC()
+		return tx.PutScheduledChirp(scheduled)
+	})
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, nil
+}
+
+// CancelScheduledChirp deletes a scheduled chirp before it is published
+func (db *DB) CancelScheduledChirp(id int) error {
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.ScheduledChirp(id); !ok {
+			return ErrScheduledChirpNotFound
+		}
+		return tx.DeleteScheduledChirp(id)
+	})
+}
+
+// PublishDueChirps turns the chirps scheduled for now or earlier into chirps,
+// in the order they were due
+func (db *DB) PublishDueChirps(now time.Time) (int, error) {
+	due := []ScheduledChirp{}
+	err := db.View(func(tx *Tx) error {
+		for _, scheduled := range tx.data.Scheduled {
+			if !scheduled.PublishAt.After(now) {
+				due = append(due, scheduled)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slices.SortFunc(due, compareScheduled)
+	ids := make([]int, 0, len(due))
+	for _, scheduled := range due {
+		ids = append(ids, scheduled.Id)
+	}
+
+	return publishDue(ids, db.publishScheduledChirp, db.CancelScheduledChirp)
+}
+
+func (db *DB) publishScheduledChirp(id int) error {
+	return db.Update(func(tx *Tx) error {
+		scheduled, ok := tx.ScheduledChirp(id)
+		if !ok {
+			return ErrScheduledChirpNotFound
+		}
+
+		if _, err := createChirp(tx, scheduled.newChirp()); err != nil {
+			return err
+		}
+		return tx.DeleteScheduledChirp(id)
+	})
+}
+
+// ScheduleChirp stores a chirp to be published at publishAt
+func (db *SQLiteDB) ScheduleChirp(chirp NewChirp, publishAt time.Time) (ScheduledChirp, error) {
+	scheduled := newScheduledChirp(chirp, publishAt)
+	flagReasons, err := json.Marshal(scheduled.FlagReasons)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	res, err := db.conn.Exec(`
+		INSERT INTO scheduled_chirps (author_id, body, in_reply_to_id, rechirp_of_id, quote_of_id, flag_reasons,
+			publish_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		scheduled.AuthorId, scheduled.Body, nullId(scheduled.InReplyToId), nullId(scheduled.RechirpOfId),
+		nullId(scheduled.QuoteOfId), string(flagReasons), scheduled.PublishAt, scheduled.CreatedAt, scheduled.UpdatedAt)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	scheduled.Id = int(id)
+	return scheduled, nil
+}
+
+// GetScheduledChirps returns an author's scheduled chirps, the next to be published first
+func (db *SQLiteDB) GetScheduledChirps(authorId int) ([]ScheduledChirp, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+scheduledColumns+` FROM scheduled_chirps
+		WHERE author_id = ?
+		ORDER BY publish_at, id`, authorId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []ScheduledChirp{}
+	for rows.Next() {
+		scheduled, err := scanScheduledChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, scheduled)
+	}
+
+	return chirps, rows.Err()
+}
+
+// GetScheduledChirpById returns a scheduled chirp, or one with a zero Id if there is none
+func (db *SQLiteDB) GetScheduledChirpById(id int) (ScheduledChirp, error) {
+	scheduled, err := scanScheduledChirp(db.conn.QueryRow(`SELECT `+scheduledColumns+` FROM scheduled_chirps WHERE id = ?`, id))
+	if errors.Is(err, ErrScheduledChirpNotFound) {
+		return ScheduledChirp{}, nil
+	}
+
+	return scheduled, err
+}
+
+// UpdateScheduledChirp replaces the body, flag reasons and publish time of a scheduled chirp
+func (db *SQLiteDB) UpdateScheduledChirp(id int, body string, flagReasons []string, publishAt time.Time) (ScheduledChirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	defer tx.Rollback()
+
+	scheduled, err := scanScheduledChirp(tx.QueryRow(`SELECT `+scheduledColumns+` FROM scheduled_chirps WHERE id = ?`, id))
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	scheduled.Body = body
+	scheduled.FlagReasons = flagReasons
+	scheduled.PublishAt = publishAt.UTC()
+	scheduled.UpdatedAt = time.Now().UTC()
+
+	reasons, err := json.Marshal(scheduled.FlagReasons)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE scheduled_chirps SET body = ?, flag_reasons = ?, publish_at = ?, updated_at = ?
+		WHERE id = ?`,
+		scheduled.Body, string(reasons), scheduled.PublishAt, scheduled.UpdatedAt, id); err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, tx.Commit()
+}
+
+// CancelScheduledChirp deletes a scheduled chirp before it is published
+func (db *SQLiteDB) CancelScheduledChirp(id int) error {
+	res, err := db.conn.Exec(`DELETE FROM scheduled_chirps WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Join(err, ErrScheduledChirpNotFound)
+	}
+	return nil
+}
+
+// PublishDueChirps turns the chirps scheduled for now or earlier into chirps,
+// in the order they were due
+func (db *SQLiteDB) PublishDueChirps(now time.Time) (int, error) {
+	rows, err := db.conn.Query(`SELECT id FROM scheduled_chirps WHERE publish_at <= ? ORDER BY publish_at, id`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return publishDue(ids, db.publishScheduledChirp, db.CancelScheduledChirp)
+}
+
+func (db *SQLiteDB) publishScheduledChirp(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	scheduled, err := scanScheduledChirp(tx.QueryRow(`SELECT `+scheduledColumns+` FROM scheduled_chirps WHERE id = ?`, id))
+	if err != nil {
+		return err
+	}
+
+	if _, err := createSQLiteChirp(tx, scheduled.newChirp()); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM scheduled_chirps WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const scheduledColumns = `id, author_id, body, in_reply_to_id, rechirp_of_id, quote_of_id, flag_reasons,
+	publish_at, created_at, updated_at`
+
+// scanScheduledChirp reads a single scheduled_chirps row selected with scheduledColumns,
+// mapping a missing row to ErrScheduledChirpNotFound
+func scanScheduledChirp(row rowScanner) (ScheduledChirp, error) {
+	var scheduled ScheduledChirp
+	var inReplyToId, rechirpOfId, quoteOfId sql.NullInt64
+	var flagReasons string
+	err := row.Scan(&scheduled.Id, &scheduled.AuthorId, &scheduled.Body, &inReplyToId, &rechirpOfId, &quoteOfId,
+		&flagReasons, &scheduled.PublishAt, &scheduled.CreatedAt, &scheduled.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScheduledChirp{}, ErrScheduledChirpNotFound
+	}
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+
+	scheduled.InReplyToId = int(inReplyToId.Int64)
+	scheduled.RechirpOfId = int(rechirpOfId.Int64)
+	scheduled.QuoteOfId = int(quoteOfId.Int64)
+	return scheduled, json.Unmarshal([]byte(flagReasons), &scheduled.FlagReasons)
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"slices"
+	"strings"
+
+	"github.com/natac13/go-chirpy/internal/search"
+)
+
+// SearchQuery is a full-text search over chirp bodies
+type SearchQuery struct {
+	// Terms are the distinct tokens searched for, see search.Tokenize,
+	// a chirp must contain every one of them
+	Terms []string
+	// Filter narrows the matches by author and time range, its paging is ignored
+	Filter ChirpQuery
+	// Offset skips that many of the ranked matches, Limit caps the page, 0 returns all of them
+	Offset int
+	Limit  int
+}
+
+// SearchPage is one page of SearchChirps results, best match first
+type SearchPage struct {
+	Chirps []Chirp
+	// Next is the Offset of the following page, 0 on the last page
+	Next int
+}
+
+// rankChirps sorts the chirps matching a search best match first,
+// newest first among equal scores, and cuts out the requested page
+func rankChirps(chirps []Chirp, query SearchQuery, stats search.Stats) SearchPage {
+	scores := make(map[int]float64, len(chirps))
+	for _, chirp := range chirps {
+		scores[chirp.Id] = search.Score(stats, query.Terms, search.Tokenize(chirp.Body))
+	}
+
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		if c := cmp.Compare(scores[b.Id], scores[a.Id]); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+
+	page := SearchPage{Chirps: []Chirp{}}
+	start, end := min(max(query.Offset, 0), len(chirps)), len(chirps)
+	if query.Limit > 0 && start+query.Limit < end {
+		end = start + query.Limit
+		page.Next = end
+	}
+
+	page.Chirps = append(page.Chirps, chirps[start:end]...)
+	return page
+}
+
+// SearchChirps ranks the chirps containing every search term, intersecting
+// the chirp ids indexed under each term
+func (db *DB) SearchChirps(query SearchQuery) (SearchPage, error) {
+	page := SearchPage{Chirps: []Chirp{}}
+	if len(query.Terms) == 0 {
+		return page, nil
+	}
+
+	err := db.View(func(tx *Tx) error {
+		idx := tx.data.idx
+		stats := search.Stats{Docs: idx.searchDocs, Tokens: idx.searchTokens, DocFreq: map[string]int{}}
+		lists := [][]int{}
+		for _, term := range query.Terms {
+			stats.DocFreq[term] = len(idx.chirpsByTerm[term])
+			lists = append(lists, idx.chirpsByTerm[term])
+		}
+
+		chirps := []Chirp{}
+		for _, id := range intersectIds(lists) {
+			chirp := tx.data.Chirps[id]
+			if !chirp.Removed() && query.Filter.matches(chirp) && query.Filter.inRange(chirp.CreatedAt) {
+				chirps = append(chirps, chirp)
+			}
+		}
+
+		page = rankChirps(chirps, query, stats)
+		return nil
+	})
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	return page, nil
+}
+
+// SearchChirps ranks the chirps containing every search term,
+// found through the terms indexed in chirp_terms
+func (db *SQLiteDB) SearchChirps(query SearchQuery) (SearchPage, error) {
+	if len(query.Terms) == 0 {
+		return SearchPage{Chirps: []Chirp{}}, nil
+	}
+
+	stats := search.Stats{DocFreq: map[string]int{}}
+	if err := db.conn.QueryRow(`SELECT docs, tokens FROM search_stats`).Scan(&stats.Docs, &stats.Tokens); err != nil {
+		return SearchPage{}, err
+	}
+
+	placeholders := "?" + strings.Repeat(", ?", len(query.Terms)-1)
+	terms := []any{}
+	for _, term := range query.Terms {
+		terms = append(terms, term)
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT term, COUNT(*) FROM chirp_terms
+		WHERE term IN (`+placeholders+`)
+		GROUP BY term`, terms...)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	for rows.Next() {
+		var term string
+		var docs int
+		if err := rows.Scan(&term, &docs); err != nil {
+			rows.Close()
+			return SearchPage{}, err
+		}
+		stats.DocFreq[term] = docs
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return SearchPage{}, err
+	}
+
+	filter := query.Filter
+	since, until := nullTime(filter.Since), nullTime(filter.Until)
+	args := append(terms, len(query.Terms), filter.ViewerId,
+		filter.AuthorId, filter.AuthorId, filter.Hashtag, filter.Hashtag, filter.MentionedId, filter.MentionedId,
+		since, since, until, until)
+	rows, err = db.conn.Query(`
+		SELECT `+chirpColumns+` FROM chirps
+		WHERE id IN (
+			SELECT chirp_id FROM chirp_terms
+			WHERE term IN (`+placeholders+`)
+			GROUP BY chirp_id
+			HAVING COUNT(*) = ?
+		)
+		AND NOT deleted AND deleted_at IS NULL
+		AND (NOT hidden OR author_id = ?)
+		AND (? = 0 OR author_id = ?)
+		AND (? = '' OR id IN (SELECT chirp_id FROM chirp_hashtags WHERE tag = ?))
+		AND (? = 0 OR id IN (SELECT chirp_id FROM chirp_mentions WHERE user_id = ?))
+		AND (? IS NULL OR created_at >= ?)
+		AND (? IS NULL OR created_at < ?)`, args...)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return SearchPage{}, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return SearchPage{}, err
+	}
+
+	return rankChirps(chirps, query, stats), nil
+}
+
+// saveSQLiteTerms indexes the terms of a chirp body in chirp_terms, replacing
+// those of its previous body, and keeps search_stats up to date.
+// An empty body removes the chirp from the index.
+func saveSQLiteTerms(tx *sql.Tx, chirpId int, body string) error {
+	var previous int
+	if err := tx.QueryRow(`SELECT token_count FROM chirps WHERE id = ?`, chirpId).Scan(&previous); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM chirp_terms WHERE chirp_id = ?`, chirpId); err != nil {
+		return err
+	}
+
+	tokens := search.Tokenize(body)
+	for _, term := range search.Terms(tokens) {
+		if _, err := tx.Exec(`INSERT INTO chirp_terms (term, chirp_id) VALUES (?, ?)`, term, chirpId); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE chirps SET token_count = ? WHERE id = ?`, len(tokens), chirpId); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE search_stats SET docs = docs + ? - ?, tokens = tokens + ? - ?`,
+		min(len(tokens), 1), min(previous, 1), len(tokens), previous)
+	return err
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Snapshot writes a consistent copy of the whole database to w
+func (db *DB) Snapshot(w io.Writer) error {
+	var data []byte
+	err := db.View(func(tx *Tx) error {
+		var err error
+		data, err = json.Marshal(tx.data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// snapshots are encrypted like the database file they were taken from
+	data, err = seal(db.aead, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Restore replaces the database with a copy written by Snapshot
+// and migrates it if it was taken by an older version
+func (db *DB) Restore(r io.Reader) error {
+	file, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	file, err = unseal(db.aead, file)
+	if err != nil {
+		return err
+	}
+
+	data := newDBStructure()
+	if err := json.Unmarshal(file, &data); err != nil {
+		return err
+	}
+
+	if err := checkVersion(data.Version, latestJSONVersion()); err != nil {
+		return err
+	}
+	data.buildIndexes()
+
+	db.mux.Lock()
+	previous := db.data
+	db.data = data
+	if err := db.writeDB(); err != nil {
+		db.data = previous
+		db.mux.Unlock()
+		return err
+	}
+	db.mux.Unlock()
+
+	_, err = db.Migrate()
+	return err
+}
+
+// Snapshot writes a consistent copy of the whole database to w
+func (db *SQLiteDB) Snapshot(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "chirpy-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot.db")
+	if _, err := db.conn.Exec(`VACUUM INTO ?`, path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Restore replaces the database with a copy written by Snapshot
+// using the sqlite online backup api, then migrates it if needed
+func (db *SQLiteDB) Restore(r io.Reader) error {
+	dir, err := os.MkdirTemp("", "chirpy-restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot.db")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	src, err := openSQLiteDB(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	ctx := context.Background()
+	srcConn, err := src.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			backup, err := destDriverConn.(*sqlite3.SQLiteConn).Backup("main", srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Migrate()
+	return err
+}
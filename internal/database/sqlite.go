@@ -0,0 +1,777 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ Store = (*SQLiteDB)(nil)
+
+// SQLiteDB is a Store backed by an embedded sqlite database
+type SQLiteDB struct {
+	conn *sql.DB
+}
+
+// NewSQLiteDB opens the sqlite database at path
+// and runs any pending migrations
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	db, err := openSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	for _, m := range applied {
+		slog.Info("DATABASE - Applied migration", "version", m.Version, "name", m.Name)
+	}
+
+	return db, nil
+}
+
+// openSQLiteDB is NewSQLiteDB without running migrations
+func openSQLiteDB(path string) (*SQLiteDB, error) {
+	dsn := "file:" + path + "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &SQLiteDB{conn: conn}
+	version, err := db.schemaVersion()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := checkVersion(version, latestSQLiteVersion()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+const (
+	chirpColumns = `id, author_id, body, created_at, updated_at, edited_at, in_reply_to_id, reply_count, deleted, like_count,
+		rechirp_of_id, quote_of_id, entities, hidden, deleted_at`
+	// qualifiedChirpColumns is chirpColumns for queries joining chirps to other tables
+	qualifiedChirpColumns = `chirps.id, chirps.author_id, chirps.body, chirps.created_at, chirps.updated_at, chirps.edited_at,
+		chirps.in_reply_to_id, chirps.reply_count, chirps.deleted, chirps.like_count,
+		chirps.rechirp_of_id, chirps.quote_of_id, chirps.entities, chirps.hidden, chirps.deleted_at`
+	userColumns = `id, email, password, is_chirpy_red, created_at, updated_at, follower_count, following_count, username,
+		flagged`
+	// qualifiedUserColumns is userColumns for queries joining users to other tables
+	qualifiedUserColumns = `users.id, users.email, users.password, users.is_chirpy_red, users.created_at, users.updated_at,
+		users.follower_count, users.following_count, users.username, users.flagged`
+)
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Close closes the underlying sqlite connection pool
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+// CreateChirp creates a new chirp and saves it to disk,
+// counting it as a reply on the chirp it replies to. A user can't
+// reply to someone who blocks them. Rechirping a chirp the author already rechirped returns the existing rechirp.
+func (db *SQLiteDB) CreateChirp(newChirp NewChirp) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := createSQLiteChirp(tx, newChirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, tx.Commit()
+}
+
+// createSQLiteChirp creates a chirp within tx, see CreateChirp
+func createSQLiteChirp(tx *sql.Tx, newChirp NewChirp) (Chirp, error) {
+	var err error
+	if newChirp.RechirpOfId != 0 {
+		newChirp.RechirpOfId, err = sqliteReferencedChirp(tx, newChirp.RechirpOfId)
+		if err != nil {
+			return Chirp{}, err
+		}
+
+		// a user rechirps a chirp at most once, one in their trash is replaced
+		existing, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE rechirp_of_id = ? AND author_id = ?`,
+			newChirp.RechirpOfId, newChirp.AuthorId))
+		if err == nil && existing.DeletedAt == nil {
+			return existing, nil
+		}
+		if err == nil {
+			err = deleteSQLiteChirp(tx, existing.Id)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return Chirp{}, err
+		}
+	}
+
+	if newChirp.QuoteOfId != 0 {
+		newChirp.QuoteOfId, err = sqliteReferencedChirp(tx, newChirp.QuoteOfId)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	if newChirp.InReplyToId != 0 {
+		res, err := tx.Exec(`UPDATE chirps SET reply_count = reply_count + 1 WHERE id = ? AND NOT deleted AND deleted_at IS NULL`,
+			newChirp.InReplyToId)
+		if err != nil {
+			return Chirp{}, err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return Chirp{}, errors.Join(err, errChirpNotFound)
+		}
+
+		var parentAuthorId int
+		err = tx.QueryRow(`SELECT author_id FROM chirps WHERE id = ?`, newChirp.InReplyToId).Scan(&parentAuthorId)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if err := checkSQLiteBlocked(tx, parentAuthorId, newChirp.AuthorId); err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`
+		INSERT INTO chirps (author_id, body, created_at, updated_at, in_reply_to_id, rechirp_of_id, quote_of_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		newChirp.AuthorId, newChirp.Body, now, now,
+		nullId(newChirp.InReplyToId), nullId(newChirp.RechirpOfId), nullId(newChirp.QuoteOfId))
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	entities, err := chirpEntities(newChirp.Body, sqliteUserByUsername(tx))
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if err := saveSQLiteEntities(tx, int(id), entities); err != nil {
+		return Chirp{}, err
+	}
+
+	if err := saveSQLiteTerms(tx, int(id), newChirp.Body); err != nil {
+		return Chirp{}, err
+	}
+
+	if len(newChirp.FlagReasons) > 0 {
+		if _, err := flagSQLiteChirp(tx, int(id), newChirp.FlagReasons); err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	return Chirp{
+		Id:          int(id),
+		Body:        newChirp.Body,
+		Entities:    entities,
+		AuthorId:    newChirp.AuthorId,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		InReplyToId: newChirp.InReplyToId,
+		RechirpOfId: newChirp.RechirpOfId,
+		QuoteOfId:   newChirp.QuoteOfId,
+	}, nil
+}
+
+// sqliteReferencedChirp returns the id of the chirp a new rechirp or quote
+// refers to, following a plain rechirp to the chirp it re-shares
+func sqliteReferencedChirp(tx *sql.Tx, chirpId int) (int, error) {
+	var id int
+	err := tx.QueryRow(`SELECT COALESCE(rechirp_of_id, id) FROM chirps WHERE id = ? AND NOT deleted AND deleted_at IS NULL`, chirpId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errChirpNotFound
+	}
+
+	return id, err
+}
+
+// GetChirps returns a page of chirps, fetching one extra row to know if there's another page
+func (db *SQLiteDB) GetChirps(query ChirpQuery) (ChirpPage, error) {
+	order, cmp := "ASC", ">"
+	if query.Sort == "desc" {
+		order, cmp = "DESC", "<"
+	}
+
+	limit := -1
+	if query.Limit > 0 {
+		limit = query.Limit + 1
+	}
+
+	since, until := nullTime(query.Since), nullTime(query.Until)
+	rows, err := db.conn.Query(`
+		SELECT `+chirpColumns+` FROM chirps
+		WHERE NOT deleted AND deleted_at IS NULL
+		AND (NOT hidden OR author_id = ?)
+		AND `+silencedClause+`
+		AND (? = 0 OR author_id = ?)
+		AND (? = '' OR id IN (SELECT chirp_id FROM chirp_hashtags WHERE tag = ?))
+		AND (? = 0 OR id IN (SELECT chirp_id FROM chirp_mentions WHERE user_id = ?))
+		AND (? = 0 OR id `+cmp+` ?)
+		AND (? IS NULL OR created_at >= ?)
+		AND (? IS NULL OR created_at < ?)
+		ORDER BY id `+order+`
+		LIMIT ?`,
+		query.ViewerId, query.MutedBy, query.MutedBy, query.MutedBy, query.AuthorId, query.AuthorId, query.Hashtag, query.Hashtag, query.MentionedId, query.MentionedId,
+		query.After, query.After, since, since, until, until, limit)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	return scanChirpPage(rows, query)
+}
+
+// scanChirpPage reads a page of chirps from rows selected with chirpColumns,
+// which hold one row more than the page when there's another page
+func scanChirpPage(rows *sql.Rows, query ChirpQuery) (ChirpPage, error) {
+	defer rows.Close()
+
+	page := ChirpPage{Chirps: []Chirp{}}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return ChirpPage{}, err
+		}
+
+		if !page.add(query, chirp) {
+			break
+		}
+	}
+
+	return page, rows.Err()
+}
+
+// GetChirpsByIds returns the chirps with the given ids that exist, keyed by id
+func (db *SQLiteDB) GetChirpsByIds(chirpIds []int) (map[int]Chirp, error) {
+	chirps := map[int]Chirp{}
+	if len(chirpIds) == 0 {
+		return chirps, nil
+	}
+
+	args := []any{}
+	for _, id := range chirpIds {
+		args = append(args, id)
+	}
+
+	rows, err := db.conn.Query(`SELECT `+chirpColumns+` FROM chirps WHERE id IN (?`+strings.Repeat(", ?", len(chirpIds)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps[chirp.Id] = chirp
+	}
+
+	return chirps, rows.Err()
+}
+
+func (db *SQLiteDB) GetChirpById(chirpId int) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, nil
+	}
+
+	return chirp, err
+}
+
+// UpdateChirp replaces the body of a chirp and its entities,
+// keeping the previous body as a revision. Flag reasons put it
+// back in the moderation queue.
+func (db *SQLiteDB) UpdateChirp(chirpId int, body string, flagReasons []string) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errChirpNotFound
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO chirp_revisions (chirp_id, version, body, created_at)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ? FROM chirp_revisions WHERE chirp_id = ?`,
+		chirpId, chirp.Body, chirp.UpdatedAt.UTC(), chirpId); err != nil {
+		return Chirp{}, err
+	}
+
+	entities, err := chirpEntities(body, sqliteUserByUsername(tx))
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	now := time.Now().UTC()
+	chirp.Body = body
+	chirp.Entities = entities
+	chirp.UpdatedAt = now
+	chirp.Edited = true
+	chirp.EditedAt = &now
+
+	if _, err := tx.Exec(`UPDATE chirps SET body = ?, updated_at = ?, edited_at = ? WHERE id = ?`,
+		chirp.Body, now, now, chirpId); err != nil {
+		return Chirp{}, err
+	}
+
+	if err := saveSQLiteEntities(tx, chirpId, entities); err != nil {
+		return Chirp{}, err
+	}
+
+	if err := saveSQLiteTerms(tx, chirpId, body); err != nil {
+		return Chirp{}, err
+	}
+
+	if len(flagReasons) > 0 {
+		if _, err := flagSQLiteChirp(tx, chirpId, flagReasons); err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	return chirp, tx.Commit()
+}
+
+// GetChirpRevisions returns the previous bodies of a chirp, oldest first
+func (db *SQLiteDB) GetChirpRevisions(chirpId int) ([]ChirpRevision, error) {
+	rows, err := db.conn.Query(`
+		SELECT chirp_id, version, body, created_at FROM chirp_revisions
+		WHERE chirp_id = ?
+		ORDER BY version`, chirpId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ChirpRevision{}
+	for rows.Next() {
+		var revision ChirpRevision
+		if err := rows.Scan(&revision.ChirpId, &revision.Version, &revision.Body, &revision.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetChirpThread returns the whole conversation the chirp is part of,
+// starting from the chirp that began it
+func (db *SQLiteDB) GetChirpThread(chirpId int) (ChirpThread, error) {
+	var rootId int
+	err := db.conn.QueryRow(`
+		WITH RECURSIVE ancestors (id, in_reply_to_id) AS (
+			SELECT id, in_reply_to_id FROM chirps WHERE id = ?
+			UNION ALL
+			SELECT chirps.id, chirps.in_reply_to_id FROM chirps
+			JOIN ancestors ON chirps.id = ancestors.in_reply_to_id
+		)
+		SELECT id FROM ancestors WHERE in_reply_to_id IS NULL`, chirpId).Scan(&rootId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChirpThread{}, nil
+	}
+	if err != nil {
+		return ChirpThread{}, err
+	}
+
+	rows, err := db.conn.Query(`
+		WITH RECURSIVE thread (id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT chirps.id FROM chirps
+			JOIN thread ON chirps.in_reply_to_id = thread.id
+		)
+		SELECT `+chirpColumns+` FROM chirps
+		WHERE id IN thread
+		ORDER BY id`, rootId)
+	if err != nil {
+		return ChirpThread{}, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return ChirpThread{}, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return ChirpThread{}, err
+	}
+
+	return buildThread(rootId, chirps), nil
+}
+
+// deleteSQLiteChirp removes a chirp or turns it into a tombstone if it has replies.
+// A tombstone is removed along with its last reply.
+func deleteSQLiteChirp(tx *sql.Tx, chirpId int) error {
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// plain rechirps have nothing to show without the chirp they re-share
+	if !chirp.Deleted {
+		rechirps, err := queryIds(tx, `SELECT id FROM chirps WHERE rechirp_of_id = ?`, chirpId)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range rechirps {
+			if err := deleteSQLiteChirp(tx, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM moderation_queue WHERE chirp_id = ?`, chirpId); err != nil {
+		return err
+	}
+
+	var hasReplies bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE in_reply_to_id = ?)`, chirpId).
+		Scan(&hasReplies); err != nil {
+		return err
+	}
+
+	if hasReplies {
+		if chirp.Deleted {
+			return nil
+		}
+
+		dead := tombstone(chirp)
+		if _, err := tx.Exec(`
+			UPDATE chirps SET body = '', deleted = TRUE, deleted_at = NULL, hidden = FALSE, edited_at = NULL, like_count = 0,
+				updated_at = ?
+			WHERE id = ?`, dead.UpdatedAt, chirpId); err != nil {
+			return err
+		}
+		if err := saveSQLiteEntities(tx, chirpId, dead.Entities); err != nil {
+			return err
+		}
+		if err := saveSQLiteTerms(tx, chirpId, dead.Body); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM chirp_revisions WHERE chirp_id = ?`, chirpId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM chirp_likes WHERE chirp_id = ?`, chirpId); err != nil {
+			return err
+		}
+	} else {
+		// dropping the chirp from the index keeps search_stats in step
+		if err := saveSQLiteTerms(tx, chirpId, ""); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM chirps WHERE id = ?`, chirpId); err != nil {
+			return err
+		}
+	}
+
+	if chirp.InReplyToId == 0 {
+		return nil
+	}
+
+	// a chirp in the trash was already taken off its parent's reply count
+	if !chirp.Removed() {
+		if _, err := tx.Exec(`UPDATE chirps SET reply_count = reply_count - 1 WHERE id = ?`, chirp.InReplyToId); err != nil {
+			return err
+		}
+	}
+
+	var orphanedTombstone bool
+	if err := tx.QueryRow(`
+		SELECT deleted AND NOT EXISTS (SELECT 1 FROM chirps WHERE in_reply_to_id = parent.id)
+		FROM chirps AS parent WHERE id = ?`, chirp.InReplyToId).Scan(&orphanedTombstone); err != nil {
+		return err
+	}
+
+	if orphanedTombstone {
+		return deleteSQLiteChirp(tx, chirp.InReplyToId)
+	}
+
+	return nil
+}
+
+func (db *SQLiteDB) CreateUser(email, password, username string) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)`, email).Scan(&exists); err != nil || exists {
+		return User{}, err
+	}
+
+	if err := checkSQLiteUsername(tx, 0, username); err != nil {
+		return User{}, err
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`INSERT INTO users (email, password, created_at, updated_at, username) VALUES (?, ?, ?, ?, ?)`,
+		email, string(hash), now, now, nullString(username))
+	if err != nil {
+		return User{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+
+	return User{
+		Id:          int(id),
+		Email:       email,
+		Username:    username,
+		Password:    string(hash),
+		IsChirpyRed: false,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, tx.Commit()
+}
+
+// UpdateUser changes the non-empty fields of a user
+func (db *SQLiteDB) UpdateUser(userId int, email, password, username string) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userId))
+	if err != nil {
+		return User{}, err
+	}
+
+	if email != "" {
+		var taken bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = ? AND id != ?)`, email, userId).Scan(&taken)
+		if err != nil {
+			return User{}, err
+		}
+		if taken {
+			return User{}, ErrEmailTaken
+		}
+		user.Email = email
+	}
+
+	if username != "" {
+		if err := checkSQLiteUsername(tx, userId, username); err != nil {
+			return User{}, err
+		}
+		user.Username = username
+	}
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return User{}, err
+		}
+
+		user.Password = string(hash)
+	}
+
+	user.UpdatedAt = time.Now().UTC()
+	if _, err := tx.Exec(`UPDATE users SET email = ?, password = ?, username = ?, updated_at = ? WHERE id = ?`,
+		user.Email, user.Password, nullString(user.Username), user.UpdatedAt, user.Id); err != nil {
+		return User{}, err
+	}
+
+	return user, tx.Commit()
+}
+
+func (db *SQLiteDB) UpgradeToChirpyRed(userId int) error {
+	res, err := db.conn.Exec(`UPDATE users SET is_chirpy_red = TRUE, updated_at = ? WHERE id = ?`,
+		time.Now().UTC(), userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errUserNotFound
+	}
+
+	return nil
+}
+
+// RevokeToken records a refresh token as revoked until it expires
+func (db *SQLiteDB) RevokeToken(token string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO revoked_tokens (token_hash, revoked_at, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (token_hash) DO UPDATE SET revoked_at = excluded.revoked_at, expires_at = excluded.expires_at`,
+		hashToken(token), time.Now().UTC(), expiresAt.UTC())
+	return err
+}
+
+func (db *SQLiteDB) IsTokenRevoked(token string) bool {
+	var exists bool
+	err := db.conn.QueryRow(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_hash = ?)`, hashToken(token)).Scan(&exists)
+	if err != nil {
+		return true
+	}
+
+	return exists
+}
+
+// PurgeExpiredTokens removes revoked tokens that have expired by now
+func (db *SQLiteDB) PurgeExpiredTokens(now time.Time) (int, error) {
+	res, err := db.conn.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (db *SQLiteDB) VerifyPassword(email, password string) (User, error) {
+	user, err := scanUser(db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+	if err != nil {
+		return User{}, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (db *SQLiteDB) GetUserById(id int) (User, error) {
+	return scanUser(db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// scanChirp reads a row selected with chirpColumns
+func scanChirp(row rowScanner) (Chirp, error) {
+	var chirp Chirp
+	var inReplyToId, rechirpOfId, quoteOfId sql.NullInt64
+	var entities sql.NullString
+	err := row.Scan(&chirp.Id, &chirp.AuthorId, &chirp.Body, &chirp.CreatedAt, &chirp.UpdatedAt, &chirp.EditedAt,
+		&inReplyToId, &chirp.ReplyCount, &chirp.Deleted, &chirp.LikeCount, &rechirpOfId, &quoteOfId, &entities,
+		&chirp.Hidden, &chirp.DeletedAt)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if entities.Valid {
+		if err := json.Unmarshal([]byte(entities.String), &chirp.Entities); err != nil {
+			return Chirp{}, err
+		}
+	}
+	chirp.Edited = chirp.EditedAt != nil
+	chirp.InReplyToId = int(inReplyToId.Int64)
+	chirp.RechirpOfId = int(rechirpOfId.Int64)
+	chirp.QuoteOfId = int(quoteOfId.Int64)
+	return chirp, nil
+}
+
+// scanUser reads a single users row selected with userColumns,
+// mapping a missing row to "User not found"
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var username sql.NullString
+	err := row.Scan(&user.Id, &user.Email, &user.Password, &user.IsChirpyRed, &user.CreatedAt, &user.UpdatedAt,
+		&user.FollowerCount, &user.FollowingCount, &username, &user.Flagged)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errUserNotFound
+	}
+
+	user.Username = username.String
+	return user, err
+}
+
+// queryIds runs a query selecting a single id column
+func queryIds(tx *sql.Tx, query string, args ...any) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// nullId maps the id 0 to NULL for optional references
+func nullId(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullString maps the empty string to NULL for optional unique columns
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullTime maps the zero time to NULL for optional query bounds
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
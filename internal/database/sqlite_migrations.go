@@ -0,0 +1,449 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type sqliteMigration struct {
+	Migration
+	up string
+	// run is for data migrations that can't be expressed in sql, it runs after up
+	run func(tx *sql.Tx) error
+}
+
+// sqliteMigrations are run in order by NewSQLiteDB, PRAGMA user_version
+// records the last one applied. Append new migrations, never reorder or edit old ones.
+var sqliteMigrations = []sqliteMigration{
+	{
+		Migration: Migration{Version: 1, Name: "create users, chirps and revoked_tokens"},
+		up: `
+			CREATE TABLE IF NOT EXISTS users (
+				id            INTEGER PRIMARY KEY AUTOINCREMENT,
+				email         TEXT    NOT NULL UNIQUE,
+				password      TEXT    NOT NULL,
+				is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE
+			);
+
+			CREATE TABLE IF NOT EXISTS chirps (
+				id        INTEGER PRIMARY KEY AUTOINCREMENT,
+				author_id INTEGER NOT NULL REFERENCES users (id),
+				body      TEXT    NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS chirps_author_id ON chirps (author_id, id);
+
+			CREATE TABLE IF NOT EXISTS revoked_tokens (
+				token      TEXT      PRIMARY KEY,
+				revoked_at TIMESTAMP NOT NULL
+			);
+		`,
+	},
+	{
+		Migration: Migration{Version: 2, Name: "hash revoked tokens and record their expiry"},
+		up: `
+			CREATE TABLE revoked_token_hashes (
+				token_hash TEXT      PRIMARY KEY,
+				revoked_at TIMESTAMP NOT NULL,
+				expires_at TIMESTAMP NOT NULL
+			);
+		`,
+		run: func(tx *sql.Tx) error {
+			rows, err := tx.Query(`SELECT token, revoked_at FROM revoked_tokens`)
+			if err != nil {
+				return err
+			}
+
+			revoked := map[string]time.Time{}
+			for rows.Next() {
+				var token string
+				var revokedAt time.Time
+				if err := rows.Scan(&token, &revokedAt); err != nil {
+					rows.Close()
+					return err
+				}
+				revoked[token] = revokedAt
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for token, revokedAt := range revoked {
+				// the expiry of tokens revoked before it was recorded is unknown,
+				// they can't outlive a refresh token issued when they were revoked
+				if _, err := tx.Exec(`INSERT INTO revoked_token_hashes (token_hash, revoked_at, expires_at) VALUES (?, ?, ?)`,
+					hashToken(token), revokedAt.UTC(), revokedAt.Add(legacyRefreshTokenLifetime).UTC()); err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.Exec(`
+				DROP TABLE revoked_tokens;
+				ALTER TABLE revoked_token_hashes RENAME TO revoked_tokens;
+				CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at);
+			`)
+			return err
+		},
+	},
+	{
+		Migration: Migration{Version: 3, Name: "add created_at and updated_at to chirps and users"},
+		up: `
+			ALTER TABLE chirps ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+			ALTER TABLE chirps ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+			ALTER TABLE users ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+			ALTER TABLE users ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+
+			CREATE INDEX chirps_created_at ON chirps (created_at);
+		`,
+		run: func(tx *sql.Tx) error {
+			// when existing rows were created is unknown,
+			// they are stamped with the time of the migration
+			now := time.Now().UTC()
+			if _, err := tx.Exec(`UPDATE chirps SET created_at = ?, updated_at = ?`, now, now); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`UPDATE users SET created_at = ?, updated_at = ?`, now, now)
+			return err
+		},
+	},
+	{
+		Migration: Migration{Version: 4, Name: "add chirp edits and revisions"},
+		up: `
+			ALTER TABLE chirps ADD COLUMN edited_at TIMESTAMP;
+
+			CREATE TABLE chirp_revisions (
+				chirp_id   INTEGER   NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+				version    INTEGER   NOT NULL,
+				body       TEXT      NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (chirp_id, version)
+			);
+		`,
+	},
+	{
+		Migration: Migration{Version: 5, Name: "add replies and tombstones to chirps"},
+		up: `
+			ALTER TABLE chirps ADD COLUMN in_reply_to_id INTEGER REFERENCES chirps (id);
+			ALTER TABLE chirps ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE chirps ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+			CREATE INDEX chirps_in_reply_to_id ON chirps (in_reply_to_id, id);
+		`,
+	},
+	{
+		Migration: Migration{Version: 6, Name: "add likes"},
+		up: `
+			ALTER TABLE chirps ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+
+			CREATE TABLE chirp_likes (
+				chirp_id   INTEGER   NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+				user_id    INTEGER   NOT NULL REFERENCES users (id),
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (chirp_id, user_id)
+			);
+
+			CREATE INDEX chirp_likes_user_id ON chirp_likes (user_id, created_at);
+		`,
+	},
+	{
+		Migration: Migration{Version: 7, Name: "add rechirps and quotes"},
+		// quotes outlive the chirp they quote, so quote_of_id isn't a foreign key
+		up: `
+			ALTER TABLE chirps ADD COLUMN rechirp_of_id INTEGER REFERENCES chirps (id);
+			ALTER TABLE chirps ADD COLUMN quote_of_id INTEGER;
+
+			CREATE UNIQUE INDEX chirps_rechirp_of_id ON chirps (rechirp_of_id, author_id)
+			WHERE rechirp_of_id IS NOT NULL;
+		`,
+	},
+	{
+		Migration: Migration{Version: 8, Name: "add follows"},
+		up: `
+			ALTER TABLE users ADD COLUMN follower_count INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE users ADD COLUMN following_count INTEGER NOT NULL DEFAULT 0;
+
+			CREATE TABLE follows (
+				follower_id INTEGER   NOT NULL REFERENCES users (id),
+				followee_id INTEGER   NOT NULL REFERENCES users (id),
+				created_at  TIMESTAMP NOT NULL,
+				PRIMARY KEY (follower_id, followee_id)
+			);
+
+			CREATE INDEX follows_followee_id ON follows (followee_id, follower_id);
+		`,
+	},
+	{
+		Migration: Migration{Version: 9, Name: "add usernames, hashtags and mentions"},
+		up: `
+			ALTER TABLE users ADD COLUMN username TEXT;
+			ALTER TABLE chirps ADD COLUMN entities TEXT;
+
+			CREATE UNIQUE INDEX users_username ON users (username COLLATE NOCASE);
+
+			CREATE TABLE chirp_hashtags (
+				tag      TEXT    NOT NULL,
+				chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+				PRIMARY KEY (tag, chirp_id)
+			);
+
+			CREATE INDEX chirp_hashtags_chirp_id ON chirp_hashtags (chirp_id);
+
+			CREATE TABLE chirp_mentions (
+				user_id  INTEGER NOT NULL REFERENCES users (id),
+				chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+				PRIMARY KEY (user_id, chirp_id)
+			);
+
+			CREATE INDEX chirp_mentions_chirp_id ON chirp_mentions (chirp_id);
+		`,
+		run: func(tx *sql.Tx) error {
+			rows, err := tx.Query(`SELECT id, body FROM chirps WHERE NOT deleted`)
+			if err != nil {
+				return err
+			}
+
+			bodies := map[int]string{}
+			for rows.Next() {
+				var id int
+				var body string
+				if err := rows.Scan(&id, &body); err != nil {
+					rows.Close()
+					return err
+				}
+				bodies[id] = body
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			// nobody had a username yet, so existing chirps only get their hashtags
+			for id, body := range bodies {
+				entities := ChirpEntities{Hashtags: parseEntities(body).Hashtags}
+				if err := saveSQLiteEntities(tx, id, entities); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Migration: Migration{Version: 10, Name: "add the search index"},
+		up: `
+			ALTER TABLE chirps ADD COLUMN token_count INTEGER NOT NULL DEFAULT 0;
+
+			CREATE TABLE chirp_terms (
+				term     TEXT    NOT NULL,
+				chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+				PRIMARY KEY (term, chirp_id)
+			);
+
+			CREATE INDEX chirp_terms_chirp_id ON chirp_terms (chirp_id);
+
+			CREATE TABLE search_stats (
+				docs   INTEGER NOT NULL,
+				tokens INTEGER NOT NULL
+			);
+
+			INSERT INTO search_stats (docs, tokens) VALUES (0, 0);
+		`,
+		run: func(tx *sql.Tx) error {
+			rows, err := tx.Query(`SELECT id, body FROM chirps`)
+			if err != nil {
+				return err
+			}
+
+			bodies := map[int]string{}
+			for rows.Next() {
+				var id int
+				var body string
+				if err := rows.Scan(&id, &body); err != nil {
+					rows.Close()
+					return err
+				}
+				bodies[id] = body
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for id, body := range bodies {
+				if err := saveSQLiteTerms(tx, id, body); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Migration: Migration{Version: 11, Name: "add the moderation queue"},
+		// an item outlives a chirp deleted by a moderator, so chirp_id has no foreign key
+		up: `
+			ALTER TABLE chirps ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+			CREATE TABLE moderation_queue (
+				chirp_id   INTEGER   PRIMARY KEY,
+				status     TEXT      NOT NULL,
+				reasons    TEXT      NOT NULL,
+				flagged_at TIMESTAMP NOT NULL,
+				decided_at TIMESTAMP,
+				note       TEXT      NOT NULL DEFAULT ''
+			);
+
+			CREATE INDEX moderation_queue_status ON moderation_queue (status, flagged_at);
+		`,
+	},
+	{
+		Migration: Migration{Version: 12, Name: "add reports"},
+		// like moderation_queue, a report outlives the chirp it is about
+		up: `
+			ALTER TABLE users ADD COLUMN flagged BOOLEAN NOT NULL DEFAULT FALSE;
+
+			CREATE TABLE reports (
+				id          INTEGER   PRIMARY KEY AUTOINCREMENT,
+				reporter_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				chirp_id    INTEGER,
+				user_id     INTEGER   REFERENCES users (id) ON DELETE CASCADE,
+				reason      TEXT      NOT NULL,
+				details     TEXT      NOT NULL DEFAULT '',
+				status      TEXT      NOT NULL,
+				created_at  TIMESTAMP NOT NULL,
+				closed_at   TIMESTAMP,
+				note        TEXT      NOT NULL DEFAULT ''
+			);
+
+			CREATE INDEX reports_chirp_id ON reports (chirp_id, status);
+			CREATE INDEX reports_user_id ON reports (user_id, status);
+			CREATE INDEX reports_status ON reports (status);
+		`,
+	},
+	{
+		Migration: Migration{Version: 13, Name: "add blocks and mutes"},
+		up: `
+			CREATE TABLE blocks (
+				blocker_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				blocked_id INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (blocker_id, blocked_id)
+			);
+
+			CREATE TABLE mutes (
+				muter_id   INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				muted_id   INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (muter_id, muted_id)
+			);
+		`,
+	},
+	{
+		Migration: Migration{Version: 14, Name: "add chirp trash"},
+		up: `
+			ALTER TABLE chirps ADD COLUMN deleted_at TIMESTAMP;
+			CREATE INDEX chirps_deleted_at ON chirps (deleted_at) WHERE deleted_at IS NOT NULL;
+		`,
+	},
+	{
+		Migration: Migration{Version: 15, Name: "add scheduled chirps"},
+		// the chirps a scheduled one refers to are checked when it is published
+		up: `
+			CREATE TABLE scheduled_chirps (
+				id             INTEGER   PRIMARY KEY AUTOINCREMENT,
+				author_id      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				body           TEXT      NOT NULL,
+				in_reply_to_id INTEGER,
+				rechirp_of_id  INTEGER,
+				quote_of_id    INTEGER,
+				flag_reasons   TEXT      NOT NULL,
+				publish_at     TIMESTAMP NOT NULL,
+				created_at     TIMESTAMP NOT NULL,
+				updated_at     TIMESTAMP NOT NULL
+			);
+
+			CREATE INDEX scheduled_chirps_author_id ON scheduled_chirps (author_id, publish_at);
+			CREATE INDEX scheduled_chirps_publish_at ON scheduled_chirps (publish_at);
+		`,
+	},
+}
+
+func latestSQLiteVersion() int {
+	return sqliteMigrations[len(sqliteMigrations)-1].Version
+}
+
+func (db *SQLiteDB) schemaVersion() (int, error) {
+	var version int
+	err := db.conn.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// PendingMigrations returns the migrations that haven't been applied yet
+func (db *SQLiteDB) PendingMigrations() ([]Migration, error) {
+	version, err := db.schemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion(version, latestSQLiteVersion()); err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, m := range sqliteMigrations {
+		if m.Version > version {
+			pending = append(pending, m.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations, each in its own transaction
+func (db *SQLiteDB) Migrate() ([]Migration, error) {
+	version, err := db.schemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion(version, latestSQLiteVersion()); err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, m := range sqliteMigrations {
+		if m.Version <= version {
+			continue
+		}
+
+		if err := db.runMigration(m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m.Migration)
+	}
+
+	return applied, nil
+}
+
+func (db *SQLiteDB) runMigration(m sqliteMigration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.up); err != nil {
+		return err
+	}
+
+	if m.run != nil {
+		if err := m.run(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.Version)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+// Store is the storage backend used by the http handlers
+type Store interface {
+	CreateChirp(chirp NewChirp) (Chirp, error)
+	GetChirps(query ChirpQuery) (ChirpPage, error)
+	GetChirpById(chirpId int) (Chirp, error)
+	GetChirpsByIds(chirpIds []int) (map[int]Chirp, error)
+	UpdateChirp(chirpId int, body string, flagReasons []string) (Chirp, error)
+	GetChirpRevisions(chirpId int) ([]ChirpRevision, error)
+	GetChirpThread(chirpId int) (ChirpThread, error)
+	DeleteChirp(chirpId int) error
+	RestoreChirp(chirpId int, deletedSince time.Time) (Chirp, error)
+	GetTrashedChirps(authorId int) ([]Chirp, error)
+	PurgeDeletedChirps(deletedBefore time.Time) (int, error)
+
+	ScheduleChirp(chirp NewChirp, publishAt time.Time) (ScheduledChirp, error)
+	GetScheduledChirps(authorId int) ([]ScheduledChirp, error)
+	GetScheduledChirpById(id int) (ScheduledChirp, error)
+	UpdateScheduledChirp(id int, body string, flagReasons []string, publishAt time.Time) (ScheduledChirp, error)
+	CancelScheduledChirp(id int) error
+	PublishDueChirps(now time.Time) (int, error)
+	SearchChirps(query SearchQuery) (SearchPage, error)
+
+	FlagChirp(chirpId int, reasons []string) (ModerationItem, error)
+	GetModerationQueue(status string) ([]ModerationItem, error)
+	ReviewChirp(chirpId int, decision, note string) (ModerationItem, error)
+
+	CreateReport(report NewReport) (Report, error)
+	GetReports(query ReportQuery) ([]Report, error)
+	CloseReport(reportId int, decision, note string) (Report, error)
+	GetFlaggedUsers() ([]User, error)
+
+	LikeChirp(chirpId, userId int) (Chirp, error)
+	UnlikeChirp(chirpId, userId int) (Chirp, error)
+	GetLikedChirps(userId int) ([]Chirp, error)
+	GetLikedChirpIds(userId int, chirpIds []int) (map[int]bool, error)
+
+	FollowUser(followerId, followeeId int) (User, error)
+	UnfollowUser(followerId, followeeId int) (User, error)
+	GetFollowers(userId int) ([]User, error)
+	GetFollowing(userId int) ([]User, error)
+	GetTimeline(userId int, query ChirpQuery) (ChirpPage, error)
+
+	BlockUser(blockerId, blockedId int) (User, error)
+	UnblockUser(blockerId, blockedId int) (User, error)
+	MuteUser(muterId, mutedId int) (User, error)
+	UnmuteUser(muterId, mutedId int) (User, error)
+	GetBlockedUsers(userId int) ([]User, error)
+	GetMutedUsers(userId int) ([]User, error)
+
+	CreateUser(email, password, username string) (User, error)
+	UpdateUser(userId int, email, password, username string) (User, error)
+	UpgradeToChirpyRed(userId int) error
+	VerifyPassword(email, password string) (User, error)
+	GetUserById(id int) (User, error)
+
+	RevokeToken(token string, expiresAt time.Time) error
+	IsTokenRevoked(token string) bool
+	PurgeExpiredTokens(now time.Time) (int, error)
+
+	PendingMigrations() ([]Migration, error)
+	Migrate() ([]Migration, error)
+
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+
+	Close() error
+}
+
+var ErrEncryptionUnsupported = errors.New("database: encryption at rest is not supported by the sqlite driver")
+
+// NewChirp is a chirp to be created by CreateChirp
+type NewChirp struct {
+	AuthorId int
+	Body     string
+	// InReplyToId is the chirp this one replies to, 0 if it isn't a reply
+	InReplyToId int
+	// RechirpOfId re-shares another chirp as is, QuoteOfId quotes one with Body as commentary
+	RechirpOfId int
+	QuoteOfId   int
+	// FlagReasons puts the chirp in the moderation queue when not empty
+	FlagReasons []string
+}
+
+// ChirpQuery filters and pages GetChirps
+type ChirpQuery struct {
+	AuthorId int
+	// Hashtag restricts chirps to those using a normalized tag,
+	// MentionedId to those mentioning a user
+	Hashtag     string
+	MentionedId int
+	// ViewerId is the user the chirps are shown to, their own hidden
+	// chirps are included. Hidden chirps of others never are.
+	ViewerId int
+	// MutedBy leaves out the chirps of the users this user blocks or mutes
+	MutedBy int
+	// Sort orders chirps by id, "asc" or "desc"
+	Sort string
+	// After continues from a previous page, only chirps past
+	// this id in sort order are returned
+	After int
+	// Limit caps the number of chirps returned, 0 returns all of them
+	Limit int
+	// Since and Until restrict chirps to those created in [Since, Until),
+	// a zero time leaves that side unbounded
+	Since time.Time
+	Until time.Time
+
+	// silenced holds the ids of the users MutedBy blocks or mutes,
+	// filled in by the json database while it runs the query
+	silenced map[int]bool
+}
+
+// matches reports whether chirp passes the author, hashtag and mention
+// filters and can be shown to the viewer
+func (q ChirpQuery) matches(chirp Chirp) bool {
+	if chirp.Hidden && chirp.AuthorId != q.ViewerId {
+		return false
+	}
+	if q.silenced[chirp.AuthorId] {
+		return false
+	}
+	if q.AuthorId != 0 && chirp.AuthorId != q.AuthorId {
+		return false
+	}
+	if q.Hashtag != "" && !chirp.Entities.hasHashtag(q.Hashtag) {
+		return false
+	}
+	if q.MentionedId != 0 && !chirp.Entities.mentions(q.MentionedId) {
+		return false
+	}
+	return true
+}
+
+// inRange reports whether a chirp created at t falls between Since and Until
+func (q ChirpQuery) inRange(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// ChirpPage is one page of GetChirps results
+type ChirpPage struct {
+	Chirps []Chirp
+	// Next is the After of the following page, 0 on the last page
+	Next int
+}
+
+// add appends chirp to the page unless the query filters it out. Once
+// the page is full the next chirp that passes the filters sets Next
+// instead and add returns false.
+func (page *ChirpPage) add(query ChirpQuery, chirp Chirp) bool {
+	if chirp.Removed() || !query.matches(chirp) || !query.inRange(chirp.CreatedAt) {
+		return true
+	}
+
+	if query.Limit > 0 && len(page.Chirps) == query.Limit {
+		page.Next = page.Chirps[len(page.Chirps)-1].Id
+		return false
+	}
+
+	page.Chirps = append(page.Chirps, chirp)
+	return true
+}
+
+// Config selects and configures a Store implementation
+type Config struct {
+	Driver string
+	Path   string
+	// SkipMigrations opens the database without migrating it,
+	// used by the migrate command to report pending migrations
+	SkipMigrations bool
+	// EncryptionKey turns on encryption at rest, see ParseEncryptionKey
+	EncryptionKey []byte
+}
+
+// Open returns the Store for the configured driver
+func Open(cfg Config) (Store, error) {
+	switch cfg.Driver {
+	case DriverJSON, "":
+		if cfg.SkipMigrations {
+			return openDB(cfg.Path, cfg.EncryptionKey)
+		}
+		return NewDB(cfg.Path, cfg.EncryptionKey)
+	case DriverSQLite:
+		if len(cfg.EncryptionKey) > 0 {
+			return nil, ErrEncryptionUnsupported
+		}
+		if cfg.SkipMigrations {
+			return openSQLiteDB(cfg.Path)
+		}
+		return NewSQLiteDB(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+// Rekey re-encrypts the database with newKey, a nil newKey decrypts it.
+// The server must not be running while the database is rekeyed.
+func Rekey(cfg Config, newKey []byte) error {
+	if cfg.Driver == DriverSQLite {
+		return ErrEncryptionUnsupported
+	}
+
+	db, err := openDB(cfg.Path, cfg.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	return db.rekey(newKey)
+}
+
+// RekeySnapshot rewrites a snapshot written by DB.Snapshot under oldKey with newKey
+func RekeySnapshot(r io.Reader, w io.Writer, oldKey, newKey []byte) error {
+	oldAEAD, err := newAEAD(oldKey)
+	if err != nil {
+		return err
+	}
+
+	newAEAD, err := newAEAD(newKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	data, err = unseal(oldAEAD, data)
+	if err != nil {
+		return err
+	}
+
+	data, err = seal(newAEAD, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
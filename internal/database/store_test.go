@@ -0,0 +1,1466 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eachStore runs fn against a fresh database of every driver
+func eachStore(t *testing.T, fn func(t *testing.T, db Store)) {
+	for _, driver := range []string{DriverJSON, DriverSQLite} {
+		t.Run(driver, func(t *testing.T) {
+			db, err := Open(Config{Driver: driver, Path: filepath.Join(t.TempDir(), "database")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			fn(t, db)
+		})
+	}
+}
+
+// createUsers creates n users with the ids 1 to n, user i is named useri
+func createUsers(t *testing.T, db Store, n int) {
+	t.Helper()
+
+	for i := 1; i <= n; i++ {
+		if _, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "password", fmt.Sprintf("user%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func chirpIds(chirps []Chirp) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+	return ids
+}
+
+// allPages follows the Next of every page of query
+func allPages(t *testing.T, db Store, query ChirpQuery) [][]int {
+	t.Helper()
+
+	pages := [][]int{}
+	for {
+		page, err := db.GetChirps(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, chirpIds(page.Chirps))
+
+		if page.Next == 0 {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatalf("pagination doesn't end, pages so far %v", pages)
+		}
+		query.After = page.Next
+	}
+}
+
+func TestWalkIds(t *testing.T) {
+	ids := []int{2, 4, 6, 8}
+
+	tests := []struct {
+		name  string
+		after int
+		desc  bool
+		stop  int
+		want  []int
+	}{
+		{"ascending", 0, false, 0, []int{2, 4, 6, 8}},
+		{"descending", 0, true, 0, []int{8, 6, 4, 2}},
+		{"ascending after an id", 4, false, 0, []int{6, 8}},
+		{"descending after an id", 6, true, 0, []int{4, 2}},
+		{"ascending after a missing id", 5, false, 0, []int{6, 8}},
+		{"descending after a missing id", 5, true, 0, []int{4, 2}},
+		{"after the last id", 8, false, 0, []int{}},
+		{"stopped early", 0, false, 4, []int{2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			walkIds(ids, tt.after, tt.desc, func(id int) bool {
+				got = append(got, id)
+				return id != tt.stop
+			})
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("walked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChirpPageAdd(t *testing.T) {
+	now := time.Now()
+	chirp := func(id, authorId int) Chirp {
+		return Chirp{Id: id, AuthorId: authorId, CreatedAt: now}
+	}
+	trashed := chirp(4, 1)
+	trashed.DeletedAt = &now
+
+	tests := []struct {
+		name     string
+		query    ChirpQuery
+		chirps   []Chirp
+		wantIds  []int
+		wantNext int
+	}{
+		{"no limit", ChirpQuery{}, []Chirp{chirp(1, 1), chirp(2, 1), chirp(3, 2)}, []int{1, 2, 3}, 0},
+		{"fits the page", ChirpQuery{Limit: 3}, []Chirp{chirp(1, 1), chirp(2, 1), chirp(3, 2)}, []int{1, 2, 3}, 0},
+		{"one more", ChirpQuery{Limit: 2}, []Chirp{chirp(1, 1), chirp(2, 1), chirp(3, 2)}, []int{1, 2}, 2},
+		{"filtered out", ChirpQuery{Limit: 2, AuthorId: 1}, []Chirp{chirp(1, 1), chirp(3, 2), chirp(2, 1)}, []int{1, 2}, 0},
+		{"filtered out after a full page", ChirpQuery{Limit: 2, AuthorId: 1}, []Chirp{chirp(1, 1), chirp(2, 1), chirp(3, 2)}, []int{1, 2}, 0},
+		{"removed after a full page", ChirpQuery{Limit: 2}, []Chirp{chirp(1, 1), chirp(2, 1), trashed}, []int{1, 2}, 0},
+		{
+			"silenced after a full page",
+			ChirpQuery{Limit: 2, MutedBy: 1, silenced: map[int]bool{3: true}},
+			[]Chirp{chirp(1, 1), chirp(2, 1), chirp(5, 3)},
+			[]int{1, 2},
+			0,
+		},
+		{
+			"match after filtered chirps",
+			ChirpQuery{Limit: 2, AuthorId: 1},
+			[]Chirp{chirp(1, 1), chirp(2, 1), chirp(3, 2), trashed, chirp(6, 1)},
+			[]int{1, 2},
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := ChirpPage{Chirps: []Chirp{}}
+			for _, chirp := range tt.chirps {
+				if !page.add(tt.query, chirp) {
+					break
+				}
+			}
+
+			if got := chirpIds(page.Chirps); !slices.Equal(got, tt.wantIds) {
+				t.Errorf("page = %v, want %v", got, tt.wantIds)
+			}
+			if page.Next != tt.wantNext {
+				t.Errorf("Next = %d, want %d", page.Next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestGetChirpsPagination(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 3)
+		for _, authorId := range []int{1, 1, 2, 1, 3} {
+			if _, err := db.CreateChirp(NewChirp{AuthorId: authorId, Body: "chirp"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.DeleteChirp(4); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.MuteUser(3, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.MuteUser(2, 3); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name      string
+			query     ChirpQuery
+			wantPages [][]int
+		}{
+			{"no limit", ChirpQuery{}, [][]int{{1, 2, 3, 5}}},
+			{"ascending", ChirpQuery{Limit: 2}, [][]int{{1, 2}, {3, 5}}},
+			{"descending", ChirpQuery{Limit: 2, Sort: "desc"}, [][]int{{5, 3}, {2, 1}}},
+			{"one per page", ChirpQuery{Limit: 1}, [][]int{{1}, {2}, {3}, {5}}},
+			{"by author", ChirpQuery{Limit: 2, AuthorId: 1}, [][]int{{1, 2}}},
+			{"by author descending", ChirpQuery{Limit: 1, AuthorId: 1, Sort: "desc"}, [][]int{{2}, {1}}},
+			{"muted author left out", ChirpQuery{Limit: 2, MutedBy: 3}, [][]int{{1, 2}, {5}}},
+			{"muted author last", ChirpQuery{Limit: 3, MutedBy: 2}, [][]int{{1, 2, 3}}},
+			{"muted author left out descending", ChirpQuery{Limit: 1, Sort: "desc", MutedBy: 3}, [][]int{{5}, {2}, {1}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				pages := allPages(t, db, tt.query)
+				if !slices.EqualFunc(pages, tt.wantPages, slices.Equal[[]int]) {
+					t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+				}
+			})
+		}
+	})
+}
+
+func TestGetChirpsTimeRange(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 1)
+
+		created := []time.Time{}
+		for i := 0; i < 3; i++ {
+			chirp, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			created = append(created, chirp.CreatedAt)
+			time.Sleep(time.Millisecond)
+		}
+
+		tests := []struct {
+			name      string
+			query     ChirpQuery
+			wantPages [][]int
+		}{
+			{"since", ChirpQuery{Since: created[1]}, [][]int{{2, 3}}},
+			{"until", ChirpQuery{Until: created[1]}, [][]int{{1}}},
+			{"since and until", ChirpQuery{Since: created[1], Until: created[2]}, [][]int{{2}}},
+			{"empty range", ChirpQuery{Since: created[2], Until: created[1]}, [][]int{{}}},
+			{"paged", ChirpQuery{Limit: 1, Since: created[1]}, [][]int{{2}, {3}}},
+			{"paged descending", ChirpQuery{Limit: 1, Sort: "desc", Until: created[2]}, [][]int{{2}, {1}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				pages := allPages(t, db, tt.query)
+				if !slices.EqualFunc(pages, tt.wantPages, slices.Equal[[]int]) {
+					t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+				}
+			})
+		}
+	})
+}
+
+func TestUpdateChirp(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 1)
+		created, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "first"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, body := range []string{"second", "third"} {
+			if _, err := db.UpdateChirp(created.Id, body, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		chirp, err := db.GetChirpById(created.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirp.Body != "third" || !chirp.Edited || chirp.EditedAt == nil {
+			t.Errorf("edited chirp = %+v, want body third marked as edited", chirp)
+		}
+		if !chirp.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("created_at changed from %v to %v", created.CreatedAt, chirp.CreatedAt)
+		}
+
+		revisions, err := db.GetChirpRevisions(created.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies, versions := []string{}, []int{}
+		for _, revision := range revisions {
+			bodies = append(bodies, revision.Body)
+			versions = append(versions, revision.Version)
+		}
+		if !slices.Equal(bodies, []string{"first", "second"}) || !slices.Equal(versions, []int{1, 2}) {
+			t.Errorf("revisions = %v versions %v, want [first second] versions [1 2]", bodies, versions)
+		}
+		if !revisions[0].CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("first revision created at %v, want %v", revisions[0].CreatedAt, created.CreatedAt)
+		}
+
+		if _, err := db.UpdateChirp(100, "missing", nil); !errors.Is(err, errChirpNotFound) {
+			t.Errorf("editing a missing chirp: error = %v, want %v", err, errChirpNotFound)
+		}
+
+		// revisions stay while the chirp is in the trash and go when it is purged
+		if err := db.DeleteChirp(created.Id); err != nil {
+			t.Fatal(err)
+		}
+		purgeTrash(t, db)
+		revisions, err = db.GetChirpRevisions(created.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 0 {
+			t.Errorf("purged chirp still has %d revisions", len(revisions))
+		}
+	})
+}
+
+// threadShape writes a thread as "id(reply reply)", with an x after tombstones
+func threadShape(thread ChirpThread) string {
+	shape := strconv.Itoa(thread.Id)
+	if thread.Deleted {
+		shape += "x"
+	}
+	if len(thread.Replies) == 0 {
+		return shape
+	}
+
+	replies := []string{}
+	for _, reply := range thread.Replies {
+		replies = append(replies, threadShape(reply))
+	}
+	return shape + "(" + strings.Join(replies, " ") + ")"
+}
+
+func TestChirpThread(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 1)
+		for _, inReplyToId := range []int{0, 1, 2, 1} {
+			if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp", InReplyToId: inReplyToId}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		assertThread := func(t *testing.T, chirpId int, want string, wantReplyCount int) {
+			t.Helper()
+
+			thread, err := db.GetChirpThread(chirpId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := threadShape(thread); got != want {
+				t.Errorf("thread of %d = %s, want %s", chirpId, got, want)
+			}
+			if thread.ReplyCount != wantReplyCount {
+				t.Errorf("root reply count = %d, want %d", thread.ReplyCount, wantReplyCount)
+			}
+		}
+
+		assertThread(t, 3, "1(2(3) 4)", 2)
+
+		// a deleted chirp with replies is left as a tombstone
+		if err := db.DeleteChirp(2); err != nil {
+			t.Fatal(err)
+		}
+		assertThread(t, 1, "1(2x(3) 4)", 1)
+
+		thread, err := db.GetChirpThread(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := thread.Replies[0].Body; body != "" {
+			t.Errorf("tombstone kept its body %q", body)
+		}
+		if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "late", InReplyToId: 2}); !errors.Is(err, errChirpNotFound) {
+			t.Errorf("replying to a tombstone: error = %v, want %v", err, errChirpNotFound)
+		}
+
+		// and removed along with its last reply
+		if err := db.DeleteChirp(3); err != nil {
+			t.Fatal(err)
+		}
+		assertThread(t, 1, "1(4)", 1)
+
+		purgeTrash(t, db)
+		for _, id := range []int{2, 3} {
+			if chirp, err := db.GetChirpById(id); err != nil || chirp.Id != 0 {
+				t.Errorf("purged chirp %d = %+v, %v, want it removed", id, chirp, err)
+			}
+		}
+		assertThread(t, 1, "1(4)", 1)
+	})
+}
+
+func TestLikeRoundTrip(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 3)
+		for i := 0; i < 2; i++ {
+			if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		steps := []struct {
+			name          string
+			like          bool
+			chirpId       int
+			userId        int
+			wantLikeCount int
+		}{
+			{"like", true, 1, 2, 1},
+			{"like again", true, 1, 2, 1},
+			{"like by another user", true, 1, 3, 2},
+			{"like another chirp", true, 2, 2, 1},
+			{"unlike", false, 1, 2, 1},
+			{"unlike again", false, 1, 2, 1},
+			{"like after unliking", true, 1, 2, 2},
+		}
+
+		for _, step := range steps {
+			change := db.UnlikeChirp
+			if step.like {
+				change = db.LikeChirp
+			}
+
+			chirp, err := change(step.chirpId, step.userId)
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if chirp.LikeCount != step.wantLikeCount || chirp.LikedByMe != step.like {
+				t.Errorf("%s: like count %d liked by me %v, want %d %v",
+					step.name, chirp.LikeCount, chirp.LikedByMe, step.wantLikeCount, step.like)
+			}
+
+			stored, err := db.GetChirpById(step.chirpId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.LikeCount != step.wantLikeCount {
+				t.Errorf("%s: stored like count %d, want %d", step.name, stored.LikeCount, step.wantLikeCount)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		liked, err := db.GetLikedChirps(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(liked); !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("liked chirps = %v, want most recently liked first [1 2]", got)
+		}
+
+		likedIds, err := db.GetLikedChirpIds(3, []int{1, 2, 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(likedIds) != 1 || !likedIds[1] {
+			t.Errorf("liked chirp ids = %v, want only 1", likedIds)
+		}
+
+		if _, err := db.LikeChirp(100, 2); !errors.Is(err, errChirpNotFound) {
+			t.Errorf("liking a missing chirp: error = %v, want %v", err, errChirpNotFound)
+		}
+
+		if err := db.DeleteChirp(1); err != nil {
+			t.Fatal(err)
+		}
+		purgeTrash(t, db)
+		liked, err = db.GetLikedChirps(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(liked); !slices.Equal(got, []int{2}) {
+			t.Errorf("liked chirps after purging one = %v, want [2]", got)
+		}
+	})
+}
+
+func TestRechirps(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 3)
+		if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "original"}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name            string
+			newChirp        NewChirp
+			wantId          int
+			wantRechirpOfId int
+			wantQuoteOfId   int
+		}{
+			{"rechirp", NewChirp{AuthorId: 2, RechirpOfId: 1}, 2, 1, 0},
+			{"rechirp again", NewChirp{AuthorId: 2, RechirpOfId: 1}, 2, 1, 0},
+			{"rechirp of a rechirp", NewChirp{AuthorId: 3, RechirpOfId: 2}, 3, 1, 0},
+			{"quote of a rechirp", NewChirp{AuthorId: 3, Body: "look", QuoteOfId: 2}, 4, 0, 1},
+		}
+
+		for _, tt := range tests {
+			chirp, err := db.CreateChirp(tt.newChirp)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if chirp.Id != tt.wantId || chirp.RechirpOfId != tt.wantRechirpOfId || chirp.QuoteOfId != tt.wantQuoteOfId {
+				t.Errorf("%s: chirp %d rechirp of %d quote of %d, want %d %d %d", tt.name,
+					chirp.Id, chirp.RechirpOfId, chirp.QuoteOfId, tt.wantId, tt.wantRechirpOfId, tt.wantQuoteOfId)
+			}
+		}
+
+		if _, err := db.CreateChirp(NewChirp{AuthorId: 2, RechirpOfId: 100}); !errors.Is(err, errChirpNotFound) {
+			t.Errorf("rechirping a missing chirp: error = %v, want %v", err, errChirpNotFound)
+		}
+
+		chirps, err := db.GetChirpsByIds([]int{1, 2, 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != 2 || chirps[1].Id != 1 || chirps[2].Id != 2 {
+			t.Errorf("chirps by ids = %v, want 1 and 2", chirps)
+		}
+
+		// plain rechirps go with the chirp they re-share, quotes stay
+		if err := db.DeleteChirp(1); err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(allChirps(t, db)); !slices.Equal(got, []int{4}) {
+			t.Errorf("chirps after deleting the original = %v, want [4]", got)
+		}
+	})
+}
+
+// purgeTrash purges every chirp in the trash
+func purgeTrash(t *testing.T, db Store) {
+	t.Helper()
+
+	if _, err := db.PurgeDeletedChirps(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func allChirps(t *testing.T, db Store) []Chirp {
+	t.Helper()
+
+	page, err := db.GetChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page.Chirps
+}
+
+func userIds(users []User) []int {
+	ids := []int{}
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+	return ids
+}
+
+func TestFollows(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 4)
+
+		for _, follow := range [][2]int{{1, 2}, {1, 3}, {4, 2}, {1, 2}} {
+			if _, err := db.FollowUser(follow[0], follow[1]); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		followee, err := db.UnfollowUser(1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if followee.Id != 3 || followee.FollowerCount != 0 {
+			t.Errorf("unfollowed user = %d with %d followers, want 3 with 0", followee.Id, followee.FollowerCount)
+		}
+
+		tests := []struct {
+			userId             int
+			wantFollowers      []int
+			wantFollowing      []int
+			wantFollowerCount  int
+			wantFollowingCount int
+		}{
+			{1, []int{}, []int{2}, 0, 1},
+			{2, []int{4, 1}, []int{}, 2, 0},
+			{3, []int{}, []int{}, 0, 0},
+			{4, []int{}, []int{2}, 0, 1},
+		}
+
+		for _, tt := range tests {
+			user, err := db.GetUserById(tt.userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.FollowerCount != tt.wantFollowerCount || user.FollowingCount != tt.wantFollowingCount {
+				t.Errorf("user %d counts %d followers and %d following, want %d and %d", tt.userId,
+					user.FollowerCount, user.FollowingCount, tt.wantFollowerCount, tt.wantFollowingCount)
+			}
+
+			followers, err := db.GetFollowers(tt.userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := userIds(followers); !slices.Equal(got, tt.wantFollowers) {
+				t.Errorf("followers of %d = %v, want %v", tt.userId, got, tt.wantFollowers)
+			}
+
+			following, err := db.GetFollowing(tt.userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := userIds(following); !slices.Equal(got, tt.wantFollowing) {
+				t.Errorf("following of %d = %v, want %v", tt.userId, got, tt.wantFollowing)
+			}
+		}
+
+		if _, err := db.FollowUser(1, 100); !errors.Is(err, errUserNotFound) {
+			t.Errorf("following a missing user: error = %v, want %v", err, errUserNotFound)
+		}
+	})
+}
+
+// timelinePages follows the Next of every page of a user's timeline
+func timelinePages(t *testing.T, db Store, userId int, query ChirpQuery) [][]int {
+	t.Helper()
+
+	pages := [][]int{}
+	for {
+		page, err := db.GetTimeline(userId, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, chirpIds(page.Chirps))
+
+		if page.Next == 0 {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatalf("pagination doesn't end, pages so far %v", pages)
+		}
+		query.After = page.Next
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 4)
+		for _, authorId := range []int{1, 2, 3, 2, 4, 1} {
+			if _, err := db.CreateChirp(NewChirp{AuthorId: authorId, Body: "chirp"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, followeeId := range []int{2, 3} {
+			if _, err := db.FollowUser(1, followeeId); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			name      string
+			userId    int
+			query     ChirpQuery
+			wantPages [][]int
+		}{
+			{"own and followed chirps", 1, ChirpQuery{}, [][]int{{6, 4, 3, 2, 1}}},
+			{"paged", 1, ChirpQuery{Limit: 2}, [][]int{{6, 4}, {3, 2}, {1}}},
+			{"following nobody", 4, ChirpQuery{}, [][]int{{5}}},
+			{"followed by someone", 2, ChirpQuery{}, [][]int{{4, 2}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				pages := timelinePages(t, db, tt.userId, tt.query)
+				if !slices.EqualFunc(pages, tt.wantPages, slices.Equal[[]int]) {
+					t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+				}
+			})
+		}
+
+		if _, err := db.UnfollowUser(1, 3); err != nil {
+			t.Fatal(err)
+		}
+		if pages := timelinePages(t, db, 1, ChirpQuery{}); !slices.EqualFunc(pages, [][]int{{6, 4, 2, 1}}, slices.Equal[[]int]) {
+			t.Errorf("timeline after unfollowing = %v, want [[6 4 2 1]]", pages)
+		}
+
+		// muting someone keeps following them but hides their chirps
+		if _, err := db.MuteUser(1, 2); err != nil {
+			t.Fatal(err)
+		}
+		if pages := timelinePages(t, db, 1, ChirpQuery{Limit: 1, MutedBy: 1}); !slices.EqualFunc(pages, [][]int{{6}, {1}}, slices.Equal[[]int]) {
+			t.Errorf("timeline muting 2 = %v, want [[6] [1]]", pages)
+		}
+		if pages := timelinePages(t, db, 1, ChirpQuery{}); !slices.EqualFunc(pages, [][]int{{6, 4, 2, 1}}, slices.Equal[[]int]) {
+			t.Errorf("timeline without MutedBy = %v, want [[6 4 2 1]]", pages)
+		}
+	})
+}
+
+func TestChirpEntities(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 2)
+
+		chirp, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "hi @User2 and @nobody #Go #go2 a@b.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.CreateChirp(NewChirp{AuthorId: 2, Body: "no entities"}); err != nil {
+			t.Fatal(err)
+		}
+
+		wantMentions := []Mention{{UserId: 2, Username: "user2", Start: 3, End: 9}}
+		if !slices.Equal(chirp.Entities.Mentions, wantMentions) {
+			t.Errorf("mentions = %+v, want %+v", chirp.Entities.Mentions, wantMentions)
+		}
+		wantHashtags := []Hashtag{{Tag: "go", Start: 22, End: 25}, {Tag: "go2", Start: 26, End: 30}}
+		if !slices.Equal(chirp.Entities.Hashtags, wantHashtags) {
+			t.Errorf("hashtags = %+v, want %+v", chirp.Entities.Hashtags, wantHashtags)
+		}
+
+		tests := []struct {
+			name  string
+			query ChirpQuery
+			want  []int
+		}{
+			{"hashtag", ChirpQuery{Hashtag: "go"}, []int{1}},
+			{"unused hashtag", ChirpQuery{Hashtag: "rust"}, []int{}},
+			{"mention", ChirpQuery{MentionedId: 2}, []int{1}},
+			{"user who isn't mentioned", ChirpQuery{MentionedId: 1}, []int{}},
+			{"hashtag and author", ChirpQuery{Hashtag: "go", AuthorId: 2}, []int{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := db.GetChirps(tt.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := chirpIds(page.Chirps); !slices.Equal(got, tt.want) {
+					t.Errorf("chirps = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		// editing a chirp reparses its entities
+		if _, err := db.UpdateChirp(chirp.Id, "now about #rust", nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range []ChirpQuery{{Hashtag: "go"}, {MentionedId: 2}} {
+			page, err := db.GetChirps(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Chirps) != 0 {
+				t.Errorf("%+v after the edit = %v, want none", query, chirpIds(page.Chirps))
+			}
+		}
+		page, err := db.GetChirps(ChirpQuery{Hashtag: "rust"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chirpIds(page.Chirps); !slices.Equal(got, []int{1}) {
+			t.Errorf("chirps tagged rust after the edit = %v, want [1]", got)
+		}
+
+		if _, err := db.CreateUser("other@example.com", "password", "USER1"); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("creating a user with a taken username: error = %v, want %v", err, ErrUsernameTaken)
+		}
+	})
+}
+
+func TestSearchChirps(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 2)
+		chirps := []NewChirp{
+			{AuthorId: 1, Body: "Go go GO"},
+			{AuthorId: 2, Body: "go is fun today friends"},
+			{AuthorId: 1, Body: "learning go with friends"},
+			{AuthorId: 2, Body: "rust only"},
+			{AuthorId: 2, Body: "go rust"},
+			{AuthorId: 1, Body: "same words"},
+			{AuthorId: 1, Body: "same words"},
+		}
+		for _, newChirp := range chirps {
+			if _, err := db.CreateChirp(newChirp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			name      string
+			query     SearchQuery
+			wantPages [][]int
+		}{
+			// repeating a term and shorter chirps rank higher
+			{"ranked", SearchQuery{Terms: []string{"go"}}, [][]int{{1, 5, 3, 2}}},
+			{"every term", SearchQuery{Terms: []string{"go", "friends"}}, [][]int{{3, 2}}},
+			{"no match", SearchQuery{Terms: []string{"go", "python"}}, [][]int{{}}},
+			{"equal scores newest first", SearchQuery{Terms: []string{"words"}}, [][]int{{7, 6}}},
+			{"paged", SearchQuery{Terms: []string{"go"}, Limit: 2}, [][]int{{1, 5}, {3, 2}}},
+			{"paged unevenly", SearchQuery{Terms: []string{"go"}, Limit: 3}, [][]int{{1, 5, 3}, {2}}},
+			{"filtered", SearchQuery{Terms: []string{"go"}, Filter: ChirpQuery{AuthorId: 2}}, [][]int{{5, 2}}},
+			{"offset past the matches", SearchQuery{Terms: []string{"go"}, Offset: 10}, [][]int{{}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				pages := searchPages(t, db, tt.query)
+				if !slices.EqualFunc(pages, tt.wantPages, slices.Equal[[]int]) {
+					t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+				}
+			})
+		}
+
+		// edited and deleted chirps are reindexed
+		if _, err := db.UpdateChirp(3, "learning rust with friends", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteChirp(5); err != nil {
+			t.Fatal(err)
+		}
+		if pages := searchPages(t, db, SearchQuery{Terms: []string{"go"}}); !slices.EqualFunc(pages, [][]int{{1, 2}}, slices.Equal[[]int]) {
+			t.Errorf("pages after the edit and delete = %v, want [[1 2]]", pages)
+		}
+	})
+}
+
+// searchPages follows the Next of every page of a search
+func searchPages(t *testing.T, db Store, query SearchQuery) [][]int {
+	t.Helper()
+
+	pages := [][]int{}
+	for {
+		page, err := db.SearchChirps(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, chirpIds(page.Chirps))
+
+		if page.Next == 0 {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatalf("pagination doesn't end, pages so far %v", pages)
+		}
+		query.Offset = page.Next
+	}
+}
+
+func moderationIds(items []ModerationItem) []int {
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.ChirpId)
+	}
+	return ids
+}
+
+func TestModerationQueue(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 2)
+		chirps := []NewChirp{
+			{AuthorId: 1, Body: "first", FlagReasons: []string{"profanity"}},
+			{AuthorId: 2, Body: "second"},
+			{AuthorId: 2, Body: "third"},
+		}
+		for _, newChirp := range chirps {
+			if _, err := db.CreateChirp(newChirp); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		assertQueue := func(t *testing.T, status string, want []int) {
+			t.Helper()
+
+			items, err := db.GetModerationQueue(status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := moderationIds(items); !slices.Equal(got, want) {
+				t.Errorf("%q queue = %v, want %v", status, got, want)
+			}
+		}
+
+		assertVisible := func(t *testing.T, viewerId int, want []int) {
+			t.Helper()
+
+			page, err := db.GetChirps(ChirpQuery{ViewerId: viewerId})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chirpIds(page.Chirps); !slices.Equal(got, want) {
+				t.Errorf("chirps shown to %d = %v, want %v", viewerId, got, want)
+			}
+		}
+
+		assertQueue(t, ModerationPending, []int{1})
+
+		if _, err := db.FlagChirp(2, []string{"spam"}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+
+		// flagging again adds the reasons and moves it to the back of the queue
+		item, err := db.FlagChirp(1, []string{"spam", "profanity"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(item.Reasons, []string{"profanity", "spam"}) {
+			t.Errorf("reasons = %v, want [profanity spam]", item.Reasons)
+		}
+		assertQueue(t, ModerationPending, []int{2, 1})
+
+		item, err = db.ReviewChirp(1, "hide", "rude")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Status != ModerationHidden || item.Note != "rude" || item.DecidedAt == nil {
+			t.Errorf("reviewed item = %+v, want hidden with the note rude", item)
+		}
+		assertVisible(t, 0, []int{2, 3})
+		assertVisible(t, 1, []int{1, 2, 3})
+
+		if _, err := db.ReviewChirp(2, "delete", ""); err != nil {
+			t.Fatal(err)
+		}
+		assertVisible(t, 0, []int{3})
+		assertQueue(t, ModerationDeleted, []int{2})
+		assertQueue(t, "", []int{2, 1})
+
+		if _, err := db.ReviewChirp(1, "approve", ""); err != nil {
+			t.Fatal(err)
+		}
+		assertVisible(t, 0, []int{1, 3})
+
+		// an edit that trips a filter puts the chirp back in the queue
+		if _, err := db.UpdateChirp(3, "third, edited", []string{"link"}); err != nil {
+			t.Fatal(err)
+		}
+		assertQueue(t, ModerationPending, []int{3})
+
+		errTests := []struct {
+			name     string
+			chirpId  int
+			decision string
+			wantErr  error
+		}{
+			{"deleted by an admin", 2, "approve", ErrNotInModerationQueue},
+			{"never flagged", 100, "approve", ErrNotInModerationQueue},
+			{"unknown decision", 1, "ban", ErrUnknownDecision},
+		}
+		for _, tt := range errTests {
+			if _, err := db.ReviewChirp(tt.chirpId, tt.decision, ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+		}
+	})
+}
+
+func reportIds(reports []Report) []int {
+	ids := []int{}
+	for _, report := range reports {
+		ids = append(ids, report.Id)
+	}
+	return ids
+}
+
+func TestReportAutoFlagging(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 5)
+		if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"}); err != nil {
+			t.Fatal(err)
+		}
+
+		chirpReports := []struct {
+			reporterId int
+			reason     string
+			wantId     int
+			wantQueue  []int
+		}{
+			{2, "spam", 1, []int{}},
+			{3, "hate", 2, []int{}},
+			// a second report from the same user is the open one
+			{2, "hate", 1, []int{}},
+			{4, "spam", 3, []int{1}},
+		}
+		for _, step := range chirpReports {
+			report, err := db.CreateReport(NewReport{ReporterId: step.reporterId, ChirpId: 1, Reason: step.reason})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Id != step.wantId {
+				t.Errorf("report by %d has id %d, want %d", step.reporterId, report.Id, step.wantId)
+			}
+
+			queue, err := db.GetModerationQueue(ModerationPending)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := moderationIds(queue); !slices.Equal(got, step.wantQueue) {
+				t.Errorf("after the report by %d the queue = %v, want %v", step.reporterId, got, step.wantQueue)
+			}
+		}
+
+		queue, err := db.GetModerationQueue(ModerationPending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"report: spam", "report: hate"}; !slices.Equal(queue[0].Reasons, want) {
+			t.Errorf("flag reasons = %v, want %v", queue[0].Reasons, want)
+		}
+
+		assertFlagged := func(t *testing.T, want []int) {
+			t.Helper()
+
+			users, err := db.GetFlaggedUsers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := userIds(users); !slices.Equal(got, want) {
+				t.Errorf("flagged users = %v, want %v", got, want)
+			}
+		}
+
+		for _, reporterId := range []int{2, 3, 4} {
+			if _, err := db.CreateReport(NewReport{ReporterId: reporterId, UserId: 5, Reason: "harassment"}); err != nil {
+				t.Fatal(err)
+			}
+			if reporterId == 3 {
+				assertFlagged(t, []int{})
+			}
+		}
+		assertFlagged(t, []int{5})
+
+		// dismissing one of the reports leaves too few to keep the user flagged
+		report, err := db.CloseReport(4, "dismiss", "not harassment")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Status != ReportDismissed || report.ClosedAt == nil || report.Note != "not harassment" {
+			t.Errorf("closed report = %+v, want dismissed with a note", report)
+		}
+		assertFlagged(t, []int{})
+
+		queryTests := []struct {
+			name  string
+			query ReportQuery
+			want  []int
+		}{
+			{"all", ReportQuery{}, []int{1, 2, 3, 4, 5, 6}},
+			{"open", ReportQuery{Status: ReportOpen}, []int{1, 2, 3, 5, 6}},
+			{"about a chirp", ReportQuery{ChirpId: 1}, []int{1, 2, 3}},
+			{"about a user", ReportQuery{UserId: 5, Status: ReportDismissed}, []int{4}},
+		}
+		for _, tt := range queryTests {
+			reports, err := db.GetReports(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reportIds(reports); !slices.Equal(got, tt.want) {
+				t.Errorf("%s: reports = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		errTests := []struct {
+			name     string
+			reportId int
+			decision string
+			wantErr  error
+		}{
+			{"closed", 4, "resolve", ErrReportClosed},
+			{"missing", 100, "resolve", ErrReportNotFound},
+			{"unknown decision", 1, "ban", ErrUnknownDecision},
+		}
+		for _, tt := range errTests {
+			if _, err := db.CloseReport(tt.reportId, tt.decision, ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+		}
+	})
+}
+
+func TestBlocksAndMutes(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 3)
+		for _, authorId := range []int{1, 2, 3} {
+			if _, err := db.CreateChirp(NewChirp{AuthorId: authorId, Body: "chirp"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, follow := range [][2]int{{1, 2}, {2, 1}, {1, 3}} {
+			if _, err := db.FollowUser(follow[0], follow[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, like := range [][2]int{{1, 2}, {2, 1}, {3, 2}} {
+			if _, err := db.LikeChirp(like[0], like[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// blocking removes the follows and likes both ways
+		if _, err := db.BlockUser(1, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.BlockUser(1, 2); err != nil {
+			t.Fatal(err)
+		}
+		following, err := db.GetFollowing(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := userIds(following); !slices.Equal(got, []int{3}) {
+			t.Errorf("user 1 follows %v after blocking 2, want [3]", got)
+		}
+		followers, err := db.GetFollowers(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(followers) != 0 {
+			t.Errorf("user 1 is followed by %v after blocking 2, want nobody", userIds(followers))
+		}
+
+		for userId, want := range map[int][]int{1: {}, 2: {3}} {
+			liked, err := db.GetLikedChirps(userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chirpIds(liked); !slices.Equal(got, want) {
+				t.Errorf("user %d likes %v after the block, want %v", userId, got, want)
+			}
+		}
+		for chirpId, want := range map[int]int{1: 0, 2: 0, 3: 1} {
+			chirp, err := db.GetChirpById(chirpId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chirp.LikeCount != want {
+				t.Errorf("chirp %d has %d likes after the block, want %d", chirpId, chirp.LikeCount, want)
+			}
+		}
+
+		blockedTests := []struct {
+			name string
+			fn   func() error
+		}{
+			{"follow", func() error { _, err := db.FollowUser(2, 1); return err }},
+			{"like", func() error { _, err := db.LikeChirp(1, 2); return err }},
+			{"reply", func() error {
+				_, err := db.CreateChirp(NewChirp{AuthorId: 2, Body: "reply", InReplyToId: 1})
+				return err
+			}},
+		}
+		for _, tt := range blockedTests {
+			if err := tt.fn(); !errors.Is(err, ErrBlocked) {
+				t.Errorf("%s by a blocked user: error = %v, want %v", tt.name, err, ErrBlocked)
+			}
+		}
+
+		// the blocker can still interact with the user they block
+		if _, err := db.LikeChirp(2, 1); err != nil {
+			t.Errorf("like by the blocker: %v", err)
+		}
+
+		if _, err := db.MuteUser(1, 3); err != nil {
+			t.Fatal(err)
+		}
+
+		listTests := []struct {
+			name string
+			fn   func(userId int) ([]User, error)
+			want []int
+		}{
+			{"blocked", db.GetBlockedUsers, []int{2}},
+			{"muted", db.GetMutedUsers, []int{3}},
+		}
+		for _, tt := range listTests {
+			users, err := tt.fn(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := userIds(users); !slices.Equal(got, tt.want) {
+				t.Errorf("%s users = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		assertSeen := func(t *testing.T, want []int) {
+			t.Helper()
+
+			page, err := db.GetChirps(ChirpQuery{MutedBy: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chirpIds(page.Chirps); !slices.Equal(got, want) {
+				t.Errorf("chirps seen by 1 = %v, want %v", got, want)
+			}
+		}
+
+		assertSeen(t, []int{1})
+
+		if _, err := db.UnblockUser(1, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.UnmuteUser(1, 3); err != nil {
+			t.Fatal(err)
+		}
+		assertSeen(t, []int{1, 2, 3})
+
+		// unblocking doesn't bring the follows back
+		if _, err := db.FollowUser(2, 1); err != nil {
+			t.Errorf("follow after unblocking: %v", err)
+		}
+		following, err = db.GetFollowing(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := userIds(following); !slices.Equal(got, []int{3}) {
+			t.Errorf("user 1 follows %v after unblocking 2, want [3]", got)
+		}
+	})
+}
+
+func TestTrash(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 2)
+		chirps := []NewChirp{
+			{AuthorId: 1, Body: "first"},
+			{AuthorId: 2, RechirpOfId: 1},
+			{AuthorId: 1, Body: "reply", InReplyToId: 1},
+			{AuthorId: 1, Body: "second"},
+		}
+		for _, newChirp := range chirps {
+			if _, err := db.CreateChirp(newChirp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.LikeChirp(1, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		assertTrash := func(t *testing.T, authorId int, want []int) {
+			t.Helper()
+
+			trashed, err := db.GetTrashedChirps(authorId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chirpIds(trashed); !slices.Equal(got, want) {
+				t.Errorf("trash of %d = %v, want %v", authorId, got, want)
+			}
+		}
+
+		// the rechirp goes into the trash with chirp 1 but isn't listed on its own
+		for _, id := range []int{1, 3} {
+			if err := db.DeleteChirp(id); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		assertTrash(t, 1, []int{3, 1})
+		assertTrash(t, 2, []int{})
+		if got := chirpIds(allChirps(t, db)); !slices.Equal(got, []int{4}) {
+			t.Errorf("chirps with 1 and 3 in the trash = %v, want [4]", got)
+		}
+
+		if _, err := db.RestoreChirp(2, time.Time{}); !errors.Is(err, ErrNotInTrash) {
+			t.Errorf("restoring a rechirp trashed with its chirp: error = %v, want %v", err, ErrNotInTrash)
+		}
+		if _, err := db.RestoreChirp(1, time.Now().Add(time.Minute)); !errors.Is(err, ErrRestoreExpired) {
+			t.Errorf("restoring too late: error = %v, want %v", err, ErrRestoreExpired)
+		}
+
+		// restoring brings back the rechirp, the like and the reply count
+		chirp, err := db.RestoreChirp(1, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirp.DeletedAt != nil || chirp.LikeCount != 1 {
+			t.Errorf("restored chirp = %+v, want it out of the trash with its like", chirp)
+		}
+		if got := chirpIds(allChirps(t, db)); !slices.Equal(got, []int{1, 2, 4}) {
+			t.Errorf("chirps after restoring 1 = %v, want [1 2 4]", got)
+		}
+		assertTrash(t, 1, []int{3})
+
+		if _, err := db.RestoreChirp(1, time.Time{}); !errors.Is(err, ErrNotInTrash) {
+			t.Errorf("restoring twice: error = %v, want %v", err, ErrNotInTrash)
+		}
+
+		if _, err := db.RestoreChirp(3, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		parent, err := db.GetChirpById(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent.ReplyCount != 1 {
+			t.Errorf("reply count after restoring the reply = %d, want 1", parent.ReplyCount)
+		}
+
+		// only chirps deleted before the cutoff are purged
+		if err := db.DeleteChirp(4); err != nil {
+			t.Fatal(err)
+		}
+		cutoff := time.Now()
+		time.Sleep(time.Millisecond)
+		if err := db.DeleteChirp(3); err != nil {
+			t.Fatal(err)
+		}
+
+		purged, err := db.PurgeDeletedChirps(cutoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged != 1 {
+			t.Errorf("purged %d chirps, want 1", purged)
+		}
+		assertTrash(t, 1, []int{3})
+		if chirp, err := db.GetChirpById(4); err != nil || chirp.Id != 0 {
+			t.Errorf("purged chirp = %+v, %v, want it removed", chirp, err)
+		}
+		if _, err := db.RestoreChirp(4, time.Time{}); !errors.Is(err, ErrNotInTrash) {
+			t.Errorf("restoring a purged chirp: error = %v, want %v", err, ErrNotInTrash)
+		}
+	})
+}
+
+func scheduledIds(chirps []ScheduledChirp) []int {
+	ids := []int{}
+	for _, scheduled := range chirps {
+		ids = append(ids, scheduled.Id)
+	}
+	return ids
+}
+
+func TestPublishDueChirps(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 2)
+		for i := 0; i < 2; i++ {
+			if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		base := time.Now()
+		schedule := []struct {
+			chirp NewChirp
+			in    time.Duration
+		}{
+			{NewChirp{AuthorId: 1, Body: "later"}, 2 * time.Hour},
+			{NewChirp{AuthorId: 1, Body: "soon"}, time.Hour},
+			{NewChirp{AuthorId: 2, Body: "reply", InReplyToId: 1}, 30 * time.Minute},
+			{NewChirp{AuthorId: 2, RechirpOfId: 2}, 45 * time.Minute},
+		}
+		for _, s := range schedule {
+			if _, err := db.ScheduleChirp(s.chirp, base.Add(s.in)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		assertScheduled := func(t *testing.T, authorId int, want []int) {
+			t.Helper()
+
+			scheduled, err := db.GetScheduledChirps(authorId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := scheduledIds(scheduled); !slices.Equal(got, want) {
+				t.Errorf("scheduled chirps of %d = %v, want %v", authorId, got, want)
+			}
+		}
+
+		assertScheduled(t, 1, []int{2, 1})
+
+		updated, err := db.UpdateScheduledChirp(1, "earlier", nil, base.Add(10*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Body != "earlier" {
+			t.Errorf("updated body = %q, want earlier", updated.Body)
+		}
+		assertScheduled(t, 1, []int{1, 2})
+
+		published, err := db.PublishDueChirps(base.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != 0 {
+			t.Errorf("published %d chirps before any was due", published)
+		}
+
+		// the rechirp of a chirp deleted in the meantime is dropped
+		if err := db.DeleteChirp(2); err != nil {
+			t.Fatal(err)
+		}
+		published, err = db.PublishDueChirps(base.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != 3 {
+			t.Errorf("published %d chirps, want 3", published)
+		}
+
+		bodies := []string{}
+		for _, chirp := range allChirps(t, db) {
+			bodies = append(bodies, chirp.Body)
+		}
+		if want := []string{"chirp", "earlier", "reply", "soon"}; !slices.Equal(bodies, want) {
+			t.Errorf("chirps = %q, want %q in the order they were due", bodies, want)
+		}
+
+		parent, err := db.GetChirpById(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent.ReplyCount != 1 {
+			t.Errorf("reply count = %d, want the published reply counted", parent.ReplyCount)
+		}
+
+		assertScheduled(t, 1, []int{})
+		assertScheduled(t, 2, []int{})
+		if scheduled, err := db.GetScheduledChirpById(4); err != nil || scheduled.Id != 0 {
+			t.Errorf("dropped scheduled chirp = %+v, %v, want it gone", scheduled, err)
+		}
+
+		published, err = db.PublishDueChirps(base.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != 0 {
+			t.Errorf("publishing again published %d chirps", published)
+		}
+
+		scheduled, err := db.ScheduleChirp(NewChirp{AuthorId: 1, Body: "never"}, base.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CancelScheduledChirp(scheduled.Id); err != nil {
+			t.Fatal(err)
+		}
+		assertScheduled(t, 1, []int{})
+
+		if err := db.CancelScheduledChirp(scheduled.Id); !errors.Is(err, ErrScheduledChirpNotFound) {
+			t.Errorf("cancelling twice: error = %v, want %v", err, ErrScheduledChirpNotFound)
+		}
+		if _, err := db.UpdateScheduledChirp(scheduled.Id, "gone", nil, base); !errors.Is(err, ErrScheduledChirpNotFound) {
+			t.Errorf("updating a cancelled chirp: error = %v, want %v", err, ErrScheduledChirpNotFound)
+		}
+	})
+}
+
+func TestUpdateUserEmail(t *testing.T) {
+	eachStore(t, func(t *testing.T, db Store) {
+		createUsers(t, db, 2)
+
+		tests := []struct {
+			name    string
+			email   string
+			wantErr error
+		}{
+			{"another user's email", "user2@example.com", ErrEmailTaken},
+			{"own email", "user1@example.com", nil},
+			{"new email", "new@example.com", nil},
+		}
+
+		for _, tt := range tests {
+			if _, err := db.UpdateUser(1, tt.email, "", ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+		}
+
+		// the user who kept their email can still log in with it
+		for email, wantId := range map[string]int{"user2@example.com": 2, "new@example.com": 1} {
+			user, err := db.VerifyPassword(email, "password")
+			if err != nil || user.Id != wantId {
+				t.Errorf("logging in as %s = user %d, %v, want user %d", email, user.Id, err, wantId)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package database
+
+import "time"
+
+// ChirpThread is a chirp with the tree of replies below it
+type ChirpThread struct {
+	Chirp
+	Replies []ChirpThread `json:"replies"`
+}
+
+// buildThread arranges the chirps of a conversation into a tree under rootId,
+// replies keep the order they have in chirps. Chirps in the trash read as
+// tombstones and are left out, along with tombstones, when nothing below them is
+// shown, which leaves an empty thread if that is the root.
+func buildThread(rootId int, chirps []Chirp) ChirpThread {
+	byId := make(map[int]Chirp, len(chirps))
+	replies := map[int][]int{}
+	for _, chirp := range chirps {
+		byId[chirp.Id] = chirp
+		if chirp.Id != rootId && chirp.InReplyToId != 0 {
+			replies[chirp.InReplyToId] = append(replies[chirp.InReplyToId], chirp.Id)
+		}
+	}
+
+	var build func(id int) (ChirpThread, bool)
+	build = func(id int) (ChirpThread, bool) {
+		thread := ChirpThread{Chirp: byId[id], Replies: []ChirpThread{}}
+		for _, replyId := range replies[id] {
+			if reply, ok := build(replyId); ok {
+				thread.Replies = append(thread.Replies, reply)
+			}
+		}
+
+		if thread.DeletedAt != nil {
+			updatedAt := thread.UpdatedAt
+			thread.Chirp = tombstone(thread.Chirp)
+			thread.UpdatedAt = updatedAt
+		}
+		return thread, !thread.Removed() || len(thread.Replies) > 0
+	}
+
+	thread, ok := build(rootId)
+	if !ok {
+		return ChirpThread{}
+	}
+	return thread
+}
+
+// tombstone is what is left of a deleted chirp that still has replies
+func tombstone(chirp Chirp) Chirp {
+	chirp.Body = ""
+	chirp.Entities = ChirpEntities{}
+	chirp.Deleted = true
+	chirp.DeletedAt = nil
+	chirp.Hidden = false
+	chirp.Edited = false
+	chirp.EditedAt = nil
+	chirp.LikeCount = 0
+	chirp.UpdatedAt = time.Now().UTC()
+	return chirp
+}
@@ -0,0 +1,318 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	ErrNotInTrash     = errors.New("Chirp is not in the trash")
+	ErrRestoreExpired = errors.New("Chirp was deleted too long ago to be restored")
+)
+
+// checkRestorable returns why a chirp can't be restored from the trash, if it can't.
+// A rechirp trashed along with the chirp it re-shares comes back with that chirp.
+func checkRestorable(chirp Chirp, originalTrashed bool, deletedSince time.Time) error {
+	if chirp.DeletedAt == nil || originalTrashed {
+		return ErrNotInTrash
+	}
+	if chirp.DeletedAt.Before(deletedSince) {
+		return ErrRestoreExpired
+	}
+	return nil
+}
+
+// DeleteChirp moves a chirp to its author's trash along with its plain rechirps.
+// It is hidden from reads but keeps its likes and revisions until it is
+// restored or purged.
+func (db *DB) DeleteChirp(chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		return trashChirp(tx, chirpId, time.Now().UTC())
+	})
+}
+
+func trashChirp(tx *Tx, chirpId int, now time.Time) error {
+	chirp, ok := tx.Chirp(chirpId)
+	if !ok || chirp.Removed() {
+		return nil
+	}
+
+	for _, id := range slices.Clone(tx.Rechirps(chirpId)) {
+		if err := trashChirp(tx, id, now); err != nil {
+			return err
+		}
+	}
+
+	chirp.DeletedAt = &now
+	if err := tx.PutChirp(chirp); err != nil {
+		return err
+	}
+
+	return adjustReplyCount(tx, chirp.InReplyToId, -1)
+}
+
+// adjustReplyCount adds delta to the reply count of parentId, if there is one
+func adjustReplyCount(tx *Tx, parentId, delta int) error {
+	parent, ok := tx.Chirp(parentId)
+	if !ok {
+		return nil
+	}
+
+	parent.ReplyCount += delta
+	return tx.PutChirp(parent)
+}
+
+// RestoreChirp takes a chirp deleted after deletedSince out of the trash,
+// with the rechirps that were trashed along with it
+func (db *DB) RestoreChirp(chirpId int, deletedSince time.Time) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpId)
+		if !ok {
+			return ErrNotInTrash
+		}
+
+		original, _ := tx.Chirp(chirp.RechirpOfId)
+		if err := checkRestorable(chirp, original.DeletedAt != nil, deletedSince); err != nil {
+			return err
+		}
+
+		deletedAt := *chirp.DeletedAt
+		chirp.DeletedAt = nil
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+
+		for _, id := range tx.Rechirps(chirpId) {
+			rechirp, _ := tx.Chirp(id)
+			if rechirp.DeletedAt == nil || !rechirp.DeletedAt.Equal(deletedAt) {
+				continue
+			}
+
+			rechirp.DeletedAt = nil
+			if err := tx.PutChirp(rechirp); err != nil {
+				return err
+			}
+		}
+
+		return adjustReplyCount(tx, chirp.InReplyToId, 1)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// GetTrashedChirps returns the chirps in an author's trash, most recently
+// deleted first. Rechirps trashed along with the chirp they re-share aren't
+// listed as they can't be restored on their own.
+func (db *DB) GetTrashedChirps(authorId int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(tx *Tx) error {
+		for _, id := range tx.data.idx.chirpsByAuthor[authorId] {
+			chirp, _ := tx.Chirp(id)
+			if chirp.DeletedAt == nil {
+				continue
+			}
+			if original, ok := tx.Chirp(chirp.RechirpOfId); ok && original.DeletedAt != nil {
+				continue
+			}
+			chirps = append(chirps, chirp)
+		}
+		return nil
+	})
+
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		if c := b.DeletedAt.Compare(*a.DeletedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+
+	return chirps, err
+}
+
+// PurgeDeletedChirps permanently deletes the chirps that went into the trash
+// before deletedBefore, leaving tombstones for those with replies
+func (db *DB) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	purged := 0
+	err := db.Update(func(tx *Tx) error {
+		ids := []int{}
+		for id, chirp := range tx.data.Chirps {
+			if chirp.DeletedAt != nil && chirp.DeletedAt.Before(deletedBefore) {
+				ids = append(ids, id)
+			}
+		}
+		slices.Sort(ids)
+
+		for _, id := range ids {
+			// purging a chirp also purges its rechirps
+			if chirp, ok := tx.Chirp(id); !ok || chirp.DeletedAt == nil {
+				continue
+			}
+
+			if err := deleteChirp(tx, id); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// DeleteChirp moves a chirp to its author's trash along with its plain rechirps.
+// It is hidden from reads but keeps its likes and revisions until it is
+// restored or purged.
+func (db *SQLiteDB) DeleteChirp(chirpId int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := trashSQLiteChirp(tx, chirpId, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func trashSQLiteChirp(tx *sql.Tx, chirpId int, now time.Time) error {
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpId))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && chirp.Removed()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rechirps, err := queryIds(tx, `SELECT id FROM chirps WHERE rechirp_of_id = ?`, chirpId)
+	if err != nil {
+		return err
+	}
+	for _, id := range rechirps {
+		if err := trashSQLiteChirp(tx, id, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE chirps SET deleted_at = ? WHERE id = ?`, now, chirpId); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE chirps SET reply_count = reply_count - 1 WHERE id = ?`, chirp.InReplyToId)
+	return err
+}
+
+// RestoreChirp takes a chirp deleted after deletedSince out of the trash,
+// with the rechirps that were trashed along with it
+func (db *SQLiteDB) RestoreChirp(chirpId int, deletedSince time.Time) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotInTrash
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	var originalTrashed bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NOT NULL)`, chirp.RechirpOfId).
+		Scan(&originalTrashed)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := checkRestorable(chirp, originalTrashed, deletedSince); err != nil {
+		return Chirp{}, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE chirps SET deleted_at = NULL
+		WHERE id = ? OR (rechirp_of_id = ? AND deleted_at = ?)`,
+		chirpId, chirpId, *chirp.DeletedAt); err != nil {
+		return Chirp{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE chirps SET reply_count = reply_count + 1 WHERE id = ?`, chirp.InReplyToId); err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.DeletedAt = nil
+	return chirp, tx.Commit()
+}
+
+// GetTrashedChirps returns the chirps in an author's trash, most recently
+// deleted first. Rechirps trashed along with the chirp they re-share aren't
+// listed as they can't be restored on their own.
+func (db *SQLiteDB) GetTrashedChirps(authorId int) ([]Chirp, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+chirpColumns+` FROM chirps
+		WHERE author_id = ? AND deleted_at IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM chirps AS original WHERE original.id = chirps.rechirp_of_id AND original.deleted_at IS NOT NULL)
+		ORDER BY deleted_at DESC, id DESC`, authorId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, rows.Err()
+}
+
+// PurgeDeletedChirps permanently deletes the chirps that went into the trash
+// before deletedBefore, leaving tombstones for those with replies
+func (db *SQLiteDB) PurgeDeletedChirps(deletedBefore time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIds(tx, `SELECT id FROM chirps WHERE deleted_at < ? ORDER BY id`, deletedBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		// purging a chirp also purges its rechirps
+		var trashed bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NOT NULL)`, id).Scan(&trashed)
+		if err != nil {
+			return 0, err
+		}
+		if !trashed {
+			continue
+		}
+
+		if err := deleteSQLiteChirp(tx, id); err != nil {
+			return 0, err
+		}
+		purged++
+	}
+
+	return purged, tx.Commit()
+}
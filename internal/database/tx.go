@@ -0,0 +1,314 @@
+package database
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+var ErrTxReadOnly = errors.New("database: write in read-only transaction")
+
+// Tx is a consistent view of the database held under the DB lock.
+// Writes made through an Update transaction are visible to later reads
+// in the same transaction and only reach disk once the callback returns nil.
+type Tx struct {
+	data     *DBStructure
+	ops      []journalOp
+	undo     []journalOp
+	writable bool
+}
+
+// View runs fn in a read-only transaction
+func (db *DB) View(fn func(tx *Tx) error) error {
+	if err := db.reloadIfChanged(); err != nil {
+		return err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return fn(&Tx{data: &db.data})
+}
+
+// Update runs fn in a read-write transaction. The lock is held for the
+// whole read-modify-write; if fn returns an error its writes are rolled back.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if err := db.reloadIfChangedLocked(); err != nil {
+		return err
+	}
+
+	tx := &Tx{data: &db.data, writable: true}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	if err := db.commit(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	return nil
+}
+
+// record applies op to the transaction's view and queues it for the journal
+func (tx *Tx) record(op journalOp) error {
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+
+	undo, err := inverseOp(tx.data, op)
+	if err != nil {
+		return err
+	}
+
+	if err := applyOp(tx.data, op); err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, op)
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
+
+// rollback reverts every write made by the transaction, newest first
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := applyOp(tx.data, tx.undo[i]); err != nil {
+			slog.Error("DATABASE - Error rolling back transaction", "error", err)
+		}
+	}
+
+	tx.ops = nil
+	tx.undo = nil
+}
+
+func (tx *Tx) put(table, key string, value any) error {
+	op, err := putOp(table, key, value)
+	if err != nil {
+		return err
+	}
+
+	return tx.record(op)
+}
+
+// nextId allocates the next id of table. Ids are never handed out twice,
+// even after the record holding one is deleted.
+func (tx *Tx) nextId(table string) (int, error) {
+	id := tx.data.Sequences[table] + 1
+	if err := tx.put(tableSequences, table, id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (tx *Tx) Chirp(id int) (Chirp, bool) {
+	chirp, ok := tx.data.Chirps[id]
+	return chirp, ok
+}
+
+func (tx *Tx) PutChirp(chirp Chirp) error {
+	return tx.put(tableChirps, strconv.Itoa(chirp.Id), chirp)
+}
+
+func (tx *Tx) DeleteChirp(id int) error {
+	return tx.record(deleteOp(tableChirps, strconv.Itoa(id)))
+}
+
+// Replies returns the ids of the direct replies to a chirp in ascending order
+func (tx *Tx) Replies(chirpId int) []int {
+	return tx.data.idx.repliesByParent[chirpId]
+}
+
+// Rechirps returns the ids of the plain rechirps of a chirp in ascending order
+func (tx *Tx) Rechirps(chirpId int) []int {
+	return tx.data.idx.rechirpsOf[chirpId]
+}
+
+// ChirpRevisions returns the revisions of a chirp, oldest first.
+// The slice is shared with the database and must not be modified in place.
+func (tx *Tx) ChirpRevisions(chirpId int) []ChirpRevision {
+	return tx.data.ChirpRevisions[chirpId]
+}
+
+func (tx *Tx) PutChirpRevisions(chirpId int, revisions []ChirpRevision) error {
+	return tx.put(tableChirpRevisions, strconv.Itoa(chirpId), revisions)
+}
+
+func (tx *Tx) DeleteChirpRevisions(chirpId int) error {
+	return tx.record(deleteOp(tableChirpRevisions, strconv.Itoa(chirpId)))
+}
+
+func (tx *Tx) Like(chirpId, userId int) (Like, bool) {
+	like, ok := tx.data.Likes[likeKey(chirpId, userId)]
+	return like, ok
+}
+
+// LikesOf returns the ids of the users who like a chirp in ascending order
+func (tx *Tx) LikesOf(chirpId int) []int {
+	return tx.data.idx.likesByChirp[chirpId]
+}
+
+// LikesBy returns the ids of the chirps a user likes in ascending order
+func (tx *Tx) LikesBy(userId int) []int {
+	return tx.data.idx.likesByUser[userId]
+}
+
+func (tx *Tx) PutLike(like Like) error {
+	return tx.put(tableLikes, likeKey(like.ChirpId, like.UserId), like)
+}
+
+func (tx *Tx) DeleteLike(chirpId, userId int) error {
+	return tx.record(deleteOp(tableLikes, likeKey(chirpId, userId)))
+}
+
+func (tx *Tx) Follow(followerId, followeeId int) (Follow, bool) {
+	follow, ok := tx.data.Follows[followKey(followerId, followeeId)]
+	return follow, ok
+}
+
+// Followers returns the ids of the users following a user in ascending order
+func (tx *Tx) Followers(userId int) []int {
+	return tx.data.idx.followersOf[userId]
+}
+
+// Following returns the ids of the users a user follows in ascending order
+func (tx *Tx) Following(userId int) []int {
+	return tx.data.idx.followingOf[userId]
+}
+
+func (tx *Tx) PutFollow(follow Follow) error {
+	return tx.put(tableFollows, followKey(follow.FollowerId, follow.FolloweeId), follow)
+}
+
+func (tx *Tx) DeleteFollow(followerId, followeeId int) error {
+	return tx.record(deleteOp(tableFollows, followKey(followerId, followeeId)))
+}
+
+func (tx *Tx) ModerationItem(chirpId int) (ModerationItem, bool) {
+	item, ok := tx.data.Moderation[chirpId]
+	return item, ok
+}
+
+func (tx *Tx) PutModerationItem(item ModerationItem) error {
+	return tx.put(tableModeration, strconv.Itoa(item.ChirpId), item)
+}
+
+func (tx *Tx) DeleteModerationItem(chirpId int) error {
+	return tx.record(deleteOp(tableModeration, strconv.Itoa(chirpId)))
+}
+
+func (tx *Tx) Block(blockerId, blockedId int) (Block, bool) {
+	block, ok := tx.data.Blocks[blockKey(blockerId, blockedId)]
+	return block, ok
+}
+
+// Blocked returns the ids of the users a user blocks in ascending order
+func (tx *Tx) Blocked(userId int) []int {
+	return tx.data.idx.blockedBy[userId]
+}
+
+func (tx *Tx) PutBlock(block Block) error {
+	return tx.put(tableBlocks, blockKey(block.BlockerId, block.BlockedId), block)
+}
+
+func (tx *Tx) DeleteBlock(blockerId, blockedId int) error {
+	return tx.record(deleteOp(tableBlocks, blockKey(blockerId, blockedId)))
+}
+
+func (tx *Tx) Mute(muterId, mutedId int) (Mute, bool) {
+	mute, ok := tx.data.Mutes[muteKey(muterId, mutedId)]
+	return mute, ok
+}
+
+// Muted returns the ids of the users a user mutes in ascending order
+func (tx *Tx) Muted(userId int) []int {
+	return tx.data.idx.mutedBy[userId]
+}
+
+func (tx *Tx) PutMute(mute Mute) error {
+	return tx.put(tableMutes, muteKey(mute.MuterId, mute.MutedId), mute)
+}
+
+func (tx *Tx) DeleteMute(muterId, mutedId int) error {
+	return tx.record(deleteOp(tableMutes, muteKey(muterId, mutedId)))
+}
+
+func (tx *Tx) Report(id int) (Report, bool) {
+	report, ok := tx.data.Reports[id]
+	return report, ok
+}
+
+func (tx *Tx) PutReport(report Report) error {
+	return tx.put(tableReports, strconv.Itoa(report.Id), report)
+}
+
+func (tx *Tx) ScheduledChirp(id int) (ScheduledChirp, bool) {
+	scheduled, ok := tx.data.Scheduled[id]
+	return scheduled, ok
+}
+
+func (tx *Tx) PutScheduledChirp(scheduled ScheduledChirp) error {
+	return tx.put(tableScheduled, strconv.Itoa(scheduled.Id), scheduled)
+}
+
+func (tx *Tx) DeleteScheduledChirp(id int) error {
+	return tx.record(deleteOp(tableScheduled, strconv.Itoa(id)))
+}
+
+func (tx *Tx) User(id int) (User, bool) {
+	user, ok := tx.data.Users[id]
+	return user, ok
+}
+
+// UserByEmail looks up a user by their email address
+func (tx *Tx) UserByEmail(email string) (User, bool) {
+	id, ok := tx.data.idx.userByEmail[email]
+	if !ok {
+		return User{}, false
+	}
+
+	return tx.User(id)
+}
+
+// UserByUsername looks up a user by their username ignoring case
+func (tx *Tx) UserByUsername(username string) (User, bool) {
+	id, ok := tx.data.idx.userByUsername[strings.ToLower(username)]
+	if !ok || username == "" {
+		return User{}, false
+	}
+
+	return tx.User(id)
+}
+
+// userByUsername is UserByUsername for chirpEntities
+func (tx *Tx) userByUsername(username string) (User, error) {
+	user, ok := tx.UserByUsername(username)
+	if !ok {
+		return User{}, errUserNotFound
+	}
+
+	return user, nil
+}
+
+func (tx *Tx) PutUser(user User) error {
+	return tx.put(tableUsers, strconv.Itoa(user.Id), user)
+}
+
+func (tx *Tx) RevokedToken(hash string) (RevokedToken, bool) {
+	revoked, ok := tx.data.RevokedTokens[hash]
+	return revoked, ok
+}
+
+func (tx *Tx) PutRevokedToken(hash string, revoked RevokedToken) error {
+	return tx.put(tableRevokedTokens, hash, revoked)
+}
+
+func (tx *Tx) DeleteRevokedToken(hash string) error {
+	return tx.record(deleteOp(tableRevokedTokens, hash))
+}
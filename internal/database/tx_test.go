@@ -0,0 +1,264 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestUpdateRollback(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	tests := []struct {
+		name string
+		fn   func(tx *Tx) error
+	}{
+		{
+			name: "insert",
+			fn: func(tx *Tx) error {
+				return tx.PutChirp(Chirp{Id: 3, AuthorId: 2, Body: "new"})
+			},
+		},
+		{
+			name: "overwrite",
+			fn: func(tx *Tx) error {
+				return tx.PutChirp(Chirp{Id: 2, AuthorId: 2, Body: "moved"})
+			},
+		},
+		{
+			name: "delete",
+			fn: func(tx *Tx) error {
+				return tx.DeleteChirp(2)
+			},
+		},
+		{
+			name: "several writes to one record",
+			fn: func(tx *Tx) error {
+				if err := tx.PutChirp(Chirp{Id: 2, AuthorId: 1, Body: "first"}); err != nil {
+					return err
+				}
+				if err := tx.DeleteChirp(2); err != nil {
+					return err
+				}
+				return tx.PutChirp(Chirp{Id: 2, AuthorId: 3, Body: "second"})
+			},
+		},
+		{
+			name: "reply",
+			fn: func(tx *Tx) error {
+				return tx.PutChirp(Chirp{Id: 3, AuthorId: 1, Body: "reply", InReplyToId: 1})
+			},
+		},
+		{
+			name: "like",
+			fn: func(tx *Tx) error {
+				return tx.PutLike(Like{ChirpId: 1, UserId: 2})
+			},
+		},
+		{
+			name: "revisions",
+			fn: func(tx *Tx) error {
+				return tx.PutChirpRevisions(2, []ChirpRevision{{ChirpId: 2, Version: 1, Body: "old"}})
+			},
+		},
+		{
+			name: "allocated id",
+			fn: func(tx *Tx) error {
+				id, err := tx.nextId(tableChirps)
+				if err != nil {
+					return err
+				}
+				return tx.PutChirp(Chirp{Id: id, AuthorId: 1, Body: "new"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, nil)
+			err := db.Update(func(tx *Tx) error {
+				if _, err := tx.nextId(tableChirps); err != nil {
+					return err
+				}
+				if _, err := tx.nextId(tableChirps); err != nil {
+					return err
+				}
+				if err := tx.PutChirp(Chirp{Id: 1, AuthorId: 1, Body: "first"}); err != nil {
+					return err
+				}
+				return tx.PutChirp(Chirp{Id: 2, AuthorId: 1, Body: "second"})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			before, err := json.Marshal(db.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := db.journalEntries
+
+			err = db.Update(func(tx *Tx) error {
+				if err := tt.fn(tx); err != nil {
+					return err
+				}
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("Update error = %v, want %v", err, errAbort)
+			}
+
+			after, err := json.Marshal(db.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(after) != string(before) {
+				t.Errorf("data after rollback = %s, want %s", after, before)
+			}
+
+			if got := db.data.idx.chirpsByAuthor[1]; !slices.Equal(got, []int{1, 2}) {
+				t.Errorf("chirpsByAuthor[1] = %v, want [1 2]", got)
+			}
+			for _, authorId := range []int{2, 3} {
+				if got := db.data.idx.chirpsByAuthor[authorId]; len(got) != 0 {
+					t.Errorf("chirpsByAuthor[%d] = %v, want none", authorId, got)
+				}
+			}
+
+			if len(db.data.idx.repliesByParent) != 0 {
+				t.Errorf("repliesByParent = %v, want none", db.data.idx.repliesByParent)
+			}
+
+			if len(db.data.idx.likesByChirp) != 0 || len(db.data.idx.likesByUser) != 0 {
+				t.Errorf("likes indexes = %v and %v, want none", db.data.idx.likesByChirp, db.data.idx.likesByUser)
+			}
+
+			if db.journalEntries != entries {
+				t.Errorf("journalEntries = %d, want %d", db.journalEntries, entries)
+			}
+
+			reopened, err := NewDB(db.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			onDisk, err := json.Marshal(reopened.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(onDisk) != string(before) {
+				t.Errorf("data on disk = %s, want %s", onDisk, before)
+			}
+		})
+	}
+}
+
+func TestInverseOp(t *testing.T) {
+	data := newTestData()
+	data.Chirps[1] = Chirp{Id: 1, AuthorId: 1, Body: "existing"}
+
+	tests := []struct {
+		name   string
+		op     journalOp
+		wantOp string
+		want   *Chirp
+	}{
+		{"put over a missing record", putChirpOp(t, 2, "new"), opDelete, nil},
+		{"delete of a missing record", deleteOp(tableChirps, "2"), opDelete, nil},
+		{"put over an existing record", putChirpOp(t, 1, "changed"), opPut, &Chirp{Id: 1, AuthorId: 1, Body: "existing"}},
+		{"delete of an existing record", deleteOp(tableChirps, "1"), opPut, &Chirp{Id: 1, AuthorId: 1, Body: "existing"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			undo, err := inverseOp(&data, tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if undo.Op != tt.wantOp || undo.Table != tt.op.Table || undo.Key != tt.op.Key {
+				t.Errorf("inverse = %s %s/%s, want %s %s/%s", undo.Op, undo.Table, undo.Key, tt.wantOp, tt.op.Table, tt.op.Key)
+			}
+
+			if tt.want != nil {
+				var got Chirp
+				if err := json.Unmarshal(undo.Value, &got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, *tt.want) {
+					t.Errorf("inverse restores %+v, want %+v", got, *tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestViewIsReadOnly(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	err := db.View(func(tx *Tx) error {
+		return tx.PutChirp(Chirp{Id: 1, AuthorId: 1, Body: "chirp"})
+	})
+	if !errors.Is(err, ErrTxReadOnly) {
+		t.Errorf("View write error = %v, want %v", err, ErrTxReadOnly)
+	}
+
+	if len(db.data.Chirps) != 0 {
+		t.Errorf("View wrote %d chirps", len(db.data.Chirps))
+	}
+}
+
+func TestNextId(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, db *DB)
+		wantId int
+	}{
+		{
+			name:   "empty database",
+			setup:  func(t *testing.T, db *DB) {},
+			wantId: 1,
+		},
+		{
+			name: "after earlier chirps",
+			setup: func(t *testing.T, db *DB) {
+				for i := 0; i < 3; i++ {
+					if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			wantId: 4,
+		},
+		{
+			name: "after the latest chirp was deleted",
+			setup: func(t *testing.T, db *DB) {
+				for i := 0; i < 3; i++ {
+					if _, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "chirp"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := db.DeleteChirp(3); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantId: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, nil)
+			tt.setup(t, db)
+
+			chirp, err := db.CreateChirp(NewChirp{AuthorId: 1, Body: "next"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chirp.Id != tt.wantId {
+				t.Errorf("new chirp got id %d, want %d", chirp.Id, tt.wantId)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+	}{
+		{"new file", ""},
+		{"replaces a file", "old contents"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file.json")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := WriteFileAtomic(path, []byte("new contents"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "new contents" {
+				t.Errorf("contents = %q, want %q", got, "new contents")
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("dir holds %d files, want only the written one", len(entries))
+			}
+		})
+	}
+}
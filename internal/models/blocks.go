@@ -0,0 +1,96 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/natac13/go-chirpy/internal/auth"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+func HandleBlockUser(db database.Store) http.HandlerFunc {
+	return handleUserRelation(db, "block", db.BlockUser)
+}
+
+func HandleUnblockUser(db database.Store) http.HandlerFunc {
+	return handleUserRelation(db, "unblock", db.UnblockUser)
+}
+
+func HandleMuteUser(db database.Store) http.HandlerFunc {
+	return handleUserRelation(db, "mute", db.MuteUser)
+}
+
+func HandleUnmuteUser(db database.Store) http.HandlerFunc {
+	return handleUserRelation(db, "unmute", db.UnmuteUser)
+}
+
+// handleUserRelation blocks, unblocks, mutes or unmutes a user for the
+// requesting user, all are idempotent and respond with the other user
+func handleUserRelation(db database.Store, verb string, change func(userId, otherId int) (database.User, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		if id == userId {
+			response.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("You can't %s yourself", verb))
+			return
+		}
+
+		if _, err := db.GetUserById(id); err != nil {
+			response.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		user, err := change(userId, id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, newPublicUserResponse(user))
+	}
+}
+
+func HandleGetBlockedUsers(db database.Store) http.HandlerFunc {
+	return handleOwnUserList(db, db.GetBlockedUsers)
+}
+
+func HandleGetMutedUsers(db database.Store) http.HandlerFunc {
+	return handleOwnUserList(db, db.GetMutedUsers)
+}
+
+// handleOwnUserList responds with the users the requesting user blocks or mutes
+func handleOwnUserList(db database.Store, list func(userId int) ([]database.User, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		users, err := list(userId)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		res := make([]PublicUserResponse, 0, len(users))
+		for _, user := range users {
+			res = append(res, newPublicUserResponse(user))
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, res)
+	}
+}
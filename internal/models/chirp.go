@@ -1,14 +1,21 @@
 package models
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/natac13/go-chirpy/internal/auth"
 	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/moderation"
 	"github.com/natac13/go-chirpy/internal/response"
 )
 
@@ -18,35 +25,181 @@ type ChirpResponse struct {
 
 type ChirpRequest struct {
 	Body string `json:"body"`
+	// InReplyToId, RechirpOfId and QuoteOfId refer to other chirps
+	// when creating one, they are ignored on edits
+	InReplyToId int `json:"in_reply_to_id"`
+	RechirpOfId int `json:"rechirp_of_id"`
+	QuoteOfId   int `json:"quote_of_id"`
+	// PublishAt schedules a new chirp to be published later, see HandleGetScheduledChirps
+	PublishAt *time.Time `json:"publish_at"`
 }
 
-func cleanChirpMessage(m string) string {
-	// bad words:
-	// kerfuffle
-	// sharbert
-	// fornax
-	listOfBadWords := []string{"kerfuffle", "sharbert", "fornax"}
-	listOfWords := strings.Split(m, " ")
-	replacement := "****"
+const maxChirpLength = 140
 
-	newWords := []string{}
+var errChirpTooLong = errors.New("Chirp is too long")
 
-	for _, word := range listOfWords {
-		if !slices.Contains(listOfBadWords, strings.ToLower((word))) {
-			newWords = append(newWords, word)
-		} else {
-			newWords = append(newWords, replacement)
+// prepareChirpBody checks the body of a new or edited chirp and moderates it,
+// the moderated body is in the result
+func prepareChirpBody(moderator *moderation.Moderator, body string) (moderation.Result, error) {
+	// the limit is in characters of the body as written, before moderation
+	if utf8.RuneCountInString(body) > maxChirpLength {
+		return moderation.Result{}, errChirpTooLong
+	}
+
+	result := moderator.Moderate(body)
+	if result.Rejected {
+		return moderation.Result{}, fmt.Errorf("Chirp rejected by moderation: %s", strings.Join(result.Reasons, ", "))
+	}
+
+	return result, nil
+}
+
+// flagReasons are the reasons to put a moderated chirp in the moderation queue, nil if it wasn't flagged
+func flagReasons(result moderation.Result) []string {
+	if !result.Flagged {
+		return nil
+	}
+	return result.Reasons
+}
+
+// logFlagged records a chirp that moderation flagged for review
+func logFlagged(chirp database.Chirp, result moderation.Result) {
+	if result.Flagged {
+		slog.Warn("MODERATION - Chirp flagged for review", "chirp_id", chirp.Id, "reasons", result.Reasons)
+	}
+}
+
+// viewerId is the requesting user, 0 for requests without a valid token
+func viewerId(r *http.Request) int {
+	userId, err := auth.ValidateToken(r)
+	if err != nil {
+		return 0
+	}
+	return userId
+}
+
+// hiddenFrom reports whether a chirp is hidden by a moderator from the viewer,
+// hidden chirps are only shown to their author
+func hiddenFrom(chirp database.Chirp, viewerId int) bool {
+	return chirp.Hidden && chirp.AuthorId != viewerId
+}
+
+const (
+	defaultChirpPageSize = 20
+	maxChirpPageSize     = 100
+)
+
+// ChirpPageResponse is returned by GET /api/chirps when paginating with limit or cursor
+type ChirpPageResponse struct {
+	Chirps     []database.Chirp `json:"chirps"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor turns the id a page ended on into an opaque cursor
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(data))
+}
+
+// decorateChirps fills in the parts of chirp responses that aren't stored
+// with the chirp: the chirps rechirps and quotes refer to and LikedByMe.
+// Chirps hidden from the requesting user, as in threads, have their body removed.
+func decorateChirps(db database.Store, r *http.Request, chirps []*database.Chirp) error {
+	viewer := viewerId(r)
+	ids := []int{}
+	for _, chirp := range chirps {
+		if hiddenFrom(*chirp, viewer) {
+			chirp.Body = ""
+			chirp.Entities = database.ChirpEntities{}
+		}
+		if chirp.RechirpOfId != 0 {
+			ids = append(ids, chirp.RechirpOfId)
 		}
+		if chirp.QuoteOfId != 0 {
+			ids = append(ids, chirp.QuoteOfId)
+		}
+	}
+
+	referenced, err := db.GetChirpsByIds(ids)
+	if err != nil {
+		return err
+	}
+
+	all := slices.Clone(chirps)
+	for _, chirp := range chirps {
+		referencedId := chirp.RechirpOfId
+		if referencedId == 0 {
+			referencedId = chirp.QuoteOfId
+		}
+
+		original, ok := referenced[referencedId]
+		if referencedId == 0 || !ok || original.Removed() || hiddenFrom(original, viewer) {
+			continue
+		}
+
+		chirp.Referenced = &original
+		all = append(all, chirp.Referenced)
+	}
+
+	return markLikedByMe(db, r, all)
+}
+
+// parseTimeRange reads since and until, RFC 3339 times bounding when chirps were created
+func parseTimeRange(r *http.Request, query *database.ChirpQuery) error {
+	var err error
+	if since := r.URL.Query().Get("since"); since != "" {
+		query.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return errors.New("Invalid since")
+		}
+	}
+
+	if until := r.URL.Query().Get("until"); until != "" {
+		query.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return errors.New("Invalid until")
+		}
+	}
+
+	return nil
+}
+
+// parsePage reads limit and cursor, a missing limit gives the default page size
+func parsePage(r *http.Request, query *database.ChirpQuery) error {
+	query.Limit = defaultChirpPageSize
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return errors.New("Invalid limit")
+		}
+		query.Limit = min(limit, maxChirpPageSize)
 	}
 
-	return strings.Join(newWords, " ")
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return errors.New("Invalid cursor")
+		}
+		query.After = after
+	}
 
+	return nil
 }
 
-func HandleGetChirps(db *database.DB) http.HandlerFunc {
+func HandleGetChirps(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s := r.URL.Query().Get("author_id")
 		sorting := r.URL.Query().Get("sort")
+		limitStr := r.URL.Query().Get("limit")
+		cursor := r.URL.Query().Get("cursor")
 
 		authorId, err := strconv.Atoi(s)
 		if err != nil {
@@ -57,39 +210,84 @@ func HandleGetChirps(db *database.DB) http.HandlerFunc {
 			sorting = "asc"
 		}
 
-		chirps, err := db.GetChirps(authorId, sorting)
+		viewer := viewerId(r)
+		query := database.ChirpQuery{
+			AuthorId: authorId,
+			ViewerId: viewer,
+			MutedBy:  viewer,
+			Sort:     sorting,
+		}
+
+		if err := parseTimeRange(r, &query); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// without limit or cursor every chirp is returned as a plain array
+		paginated := limitStr != "" || cursor != ""
+		if paginated {
+			if err := parsePage(r, &query); err != nil {
+				response.RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		page, err := db.GetChirps(query)
 		if err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		response.RespondWithJSON(w, http.StatusOK, chirps)
+		if err := decorateChirps(db, r, chirpRefs(page.Chirps)); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !paginated {
+			response.RespondWithJSON(w, http.StatusOK, page.Chirps)
+			return
+		}
+
+		res := ChirpPageResponse{Chirps: page.Chirps}
+		if page.Next != 0 {
+			res.NextCursor = encodeCursor(page.Next)
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, res)
 	}
 }
 
-func HandleGetChirp(db *database.DB) http.HandlerFunc {
+func HandleGetChirp(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("id")
 		id, err := strconv.Atoi(idStr)
-		chirps, err := db.GetChirps(0, "asc")
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
+		chirp, err := db.GetChirpById(id)
 		if err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		for _, chirp := range chirps {
-			if chirp.Id == id {
-				response.RespondWithJSON(w, http.StatusOK, chirp)
-				return
-			}
+		if chirp.Id == 0 || chirp.Removed() || hiddenFrom(chirp, viewerId(r)) {
+			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+
+		if err := decorateChirps(db, r, []*database.Chirp{&chirp}); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
 
-		response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+		response.RespondWithJSON(w, http.StatusOK, chirp)
 	}
 
 }
 
-func HandleCreateChirp(db *database.DB) http.HandlerFunc {
+func HandleCreateChirp(db database.Store, moderator *moderation.Moderator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userId, err := auth.ValidateToken(r)
@@ -106,22 +304,90 @@ func HandleCreateChirp(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		if len(chirpRequest.Body) > 140 {
-			response.RespondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		if chirpRequest.PublishAt != nil && !chirpRequest.PublishAt.After(time.Now()) {
+			response.RespondWithError(w, http.StatusBadRequest, errPublishAtPast.Error())
 			return
 		}
 
-		chirp, err := db.CreateChirp(chirpRequest.Body, userId)
+		// a plain rechirp re-shares a chirp as is
+		if chirpRequest.RechirpOfId != 0 &&
+			(chirpRequest.Body != "" || chirpRequest.InReplyToId != 0 || chirpRequest.QuoteOfId != 0) {
+			response.RespondWithError(w, http.StatusBadRequest, "A rechirp can't have a body, use quote_of_id to comment on a chirp")
+			return
+		}
+
+		moderated, err := prepareChirpBody(moderator, chirpRequest.Body)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		references := []struct {
+			id      int
+			missing string
+		}{
+			{chirpRequest.InReplyToId, "Chirp being replied to not found"},
+			{chirpRequest.RechirpOfId, "Chirp being rechirped not found"},
+			{chirpRequest.QuoteOfId, "Chirp being quoted not found"},
+		}
+		for _, ref := range references {
+			if ref.id == 0 {
+				continue
+			}
+
+			referenced, err := db.GetChirpById(ref.id)
+			if err != nil {
+				response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			if referenced.Id == 0 || referenced.Removed() || hiddenFrom(referenced, userId) {
+				response.RespondWithError(w, http.StatusNotFound, ref.missing)
+				return
+			}
+		}
+
+		newChirp := database.NewChirp{
+			AuthorId:    userId,
+			Body:        moderated.Body,
+			InReplyToId: chirpRequest.InReplyToId,
+			RechirpOfId: chirpRequest.RechirpOfId,
+			QuoteOfId:   chirpRequest.QuoteOfId,
+			FlagReasons: flagReasons(moderated),
+		}
+
+		if chirpRequest.PublishAt != nil {
+			scheduled, err := db.ScheduleChirp(newChirp, *chirpRequest.PublishAt)
+			if err != nil {
+				response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			response.RespondWithJSON(w, http.StatusCreated, scheduledForAuthor(scheduled))
+			return
+		}
+
+		chirp, err := db.CreateChirp(newChirp)
+		if errors.Is(err, database.ErrBlocked) {
+			response.RespondWithError(w, http.StatusForbidden, "You are blocked by the author of the chirp being replied to")
+			return
+		}
 		if err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		logFlagged(chirp, moderated)
+
+		if err := decorateChirps(db, r, []*database.Chirp{&chirp}); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 
 		response.RespondWithJSON(w, http.StatusCreated, chirp)
 	}
 }
 
-func HandleDeleteChirp(db *database.DB) http.HandlerFunc {
+func HandleUpdateChirp(db database.Store, moderator *moderation.Moderator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userId, err := auth.ValidateToken(r)
 		if err != nil {
@@ -136,8 +402,136 @@ func HandleDeleteChirp(db *database.DB) http.HandlerFunc {
 			return
 		}
 
+		decoder := json.NewDecoder(r.Body)
+		var chirpRequest ChirpRequest
+		err = decoder.Decode(&chirpRequest)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		moderated, err := prepareChirpBody(moderator, chirpRequest.Body)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		chirp, err := db.GetChirpById(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if chirp.Id == 0 || chirp.Removed() {
+			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+
+		if chirp.AuthorId != userId {
+			response.RespondWithError(w, http.StatusForbidden, "You are not the author of this chirp")
+			return
+		}
+
+		if chirp.RechirpOfId != 0 {
+			response.RespondWithError(w, http.StatusBadRequest, "Rechirps can't be edited")
+			return
+		}
+
+		chirp, err = db.UpdateChirp(id, moderated.Body, flagReasons(moderated))
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logFlagged(chirp, moderated)
+
+		if err := decorateChirps(db, r, []*database.Chirp{&chirp}); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, chirp)
+	}
+}
+
+func HandleGetChirpRevisions(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
 		chirp, err := db.GetChirpById(id)
 		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if chirp.Id == 0 || chirp.Removed() || hiddenFrom(chirp, viewerId(r)) {
+			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+
+		revisions, err := db.GetChirpRevisions(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, revisions)
+	}
+}
+
+// HandleGetChirpThread returns the conversation a chirp is part of as a tree.
+// Deleted chirps with replies appear as tombstones.
+func HandleGetChirpThread(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
+		thread, err := db.GetChirpThread(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if thread.Id == 0 {
+			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+
+		if err := decorateChirps(db, r, threadRefs(&thread)); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, thread)
+	}
+}
+
+// HandleDeleteChirp moves a chirp to its author's trash, see HandleRestoreChirp
+func HandleDeleteChirp(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
+		chirp, err := db.GetChirpById(id)
+		if err != nil || chirp.Id == 0 || chirp.Removed() {
 			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
 			return
 		}
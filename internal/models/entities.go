@@ -0,0 +1,75 @@
+package models
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+// HandleGetHashtagChirps returns the chirps using a hashtag, newest first,
+// the tag is matched ignoring case and with or without the #
+func HandleGetHashtagChirps(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag, ok := database.NormalizeHashtag(r.PathValue("tag"))
+		if !ok {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid hashtag")
+			return
+		}
+
+		respondWithChirpFeed(w, r, db, database.ChirpQuery{Hashtag: tag, Sort: "desc"})
+	}
+}
+
+// HandleGetUserMentions returns the chirps mentioning a user, newest first
+func HandleGetUserMentions(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		if _, err := db.GetUserById(id); err != nil {
+			response.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		respondWithChirpFeed(w, r, db, database.ChirpQuery{MentionedId: id, Sort: "desc"})
+	}
+}
+
+// respondWithChirpFeed responds with a page of the chirps matching query,
+// narrowed by the since and until parameters and paged with limit and cursor
+func respondWithChirpFeed(w http.ResponseWriter, r *http.Request, db database.Store, query database.ChirpQuery) {
+	query.ViewerId = viewerId(r)
+	if err := parseTimeRange(r, &query); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := parsePage(r, &query); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := db.GetChirps(query)
+	if err != nil {
+		response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := decorateChirps(db, r, chirpRefs(page.Chirps)); err != nil {
+		response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := ChirpPageResponse{Chirps: page.Chirps}
+	if page.Next != 0 {
+		res.NextCursor = encodeCursor(page.Next)
+	}
+
+	response.RespondWithJSON(w, http.StatusOK, res)
+}
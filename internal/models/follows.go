@@ -0,0 +1,139 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/natac13/go-chirpy/internal/auth"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+func HandleFollowUser(db database.Store) http.HandlerFunc {
+	return handleFollow(db, db.FollowUser)
+}
+
+func HandleUnfollowUser(db database.Store) http.HandlerFunc {
+	return handleFollow(db, db.UnfollowUser)
+}
+
+// handleFollow follows or unfollows a user for the requesting user,
+// both are idempotent and respond with the user being followed
+func handleFollow(db database.Store, change func(followerId, followeeId int) (database.User, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		if id == userId {
+			response.RespondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+			return
+		}
+
+		if _, err := db.GetUserById(id); err != nil {
+			response.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		user, err := change(userId, id)
+		if errors.Is(err, database.ErrBlocked) {
+			response.RespondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, newPublicUserResponse(user))
+	}
+}
+
+func HandleGetFollowers(db database.Store) http.HandlerFunc {
+	return handleFollowList(db, db.GetFollowers)
+}
+
+func HandleGetFollowing(db database.Store) http.HandlerFunc {
+	return handleFollowList(db, db.GetFollowing)
+}
+
+// handleFollowList responds with the followers of a user or the users they follow
+func handleFollowList(db database.Store, list func(userId int) ([]database.User, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		if _, err := db.GetUserById(id); err != nil {
+			response.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		users, err := list(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		res := make([]PublicUserResponse, 0, len(users))
+		for _, user := range users {
+			res = append(res, newPublicUserResponse(user))
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, res)
+	}
+}
+
+// HandleGetTimeline returns the chirps of the requesting user and everyone
+// they follow, newest first, a page at a time
+func HandleGetTimeline(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		query := database.ChirpQuery{ViewerId: userId, MutedBy: userId, Sort: "desc"}
+		if err := parseTimeRange(r, &query); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := parsePage(r, &query); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		page, err := db.GetTimeline(userId, query)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := decorateChirps(db, r, chirpRefs(page.Chirps)); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		res := ChirpPageResponse{Chirps: page.Chirps}
+		if page.Next != 0 {
+			res.NextCursor = encodeCursor(page.Next)
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, res)
+	}
+}
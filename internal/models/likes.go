@@ -0,0 +1,142 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/natac13/go-chirpy/internal/auth"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+func HandleLikeChirp(db database.Store) http.HandlerFunc {
+	return handleLike(db, db.LikeChirp)
+}
+
+func HandleUnlikeChirp(db database.Store) http.HandlerFunc {
+	return handleLike(db, db.UnlikeChirp)
+}
+
+// handleLike likes or unlikes a chirp for the requesting user, both are idempotent
+func handleLike(db database.Store, change func(chirpId, userId int) (database.Chirp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
+		chirp, err := db.GetChirpById(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if chirp.Id == 0 || chirp.Removed() || hiddenFrom(chirp, userId) {
+			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+
+		chirp, err = change(id, userId)
+		if errors.Is(err, database.ErrBlocked) {
+			response.RespondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := decorateChirps(db, r, []*database.Chirp{&chirp}); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, chirp)
+	}
+}
+
+// HandleGetUserLikes returns the chirps a user likes, most recently liked first
+func HandleGetUserLikes(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		if _, err := db.GetUserById(id); err != nil {
+			response.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		chirps, err := db.GetLikedChirps(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		viewer := viewerId(r)
+		chirps = slices.DeleteFunc(chirps, func(chirp database.Chirp) bool {
+			return chirp.Removed() || hiddenFrom(chirp, viewer)
+		})
+
+		if err := decorateChirps(db, r, chirpRefs(chirps)); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, chirps)
+	}
+}
+
+// markLikedByMe sets LikedByMe on the chirps the requesting user likes,
+// requests without a valid token leave them all unset
+func markLikedByMe(db database.Store, r *http.Request, chirps []*database.Chirp) error {
+	userId, err := auth.ValidateToken(r)
+	if err != nil || len(chirps) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+
+	liked, err := db.GetLikedChirpIds(userId, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, chirp := range chirps {
+		chirp.LikedByMe = liked[chirp.Id]
+	}
+	return nil
+}
+
+func chirpRefs(chirps []database.Chirp) []*database.Chirp {
+	refs := make([]*database.Chirp, 0, len(chirps))
+	for i := range chirps {
+		refs = append(refs, &chirps[i])
+	}
+	return refs
+}
+
+// threadRefs returns every chirp in a thread
+func threadRefs(thread *database.ChirpThread) []*database.Chirp {
+	refs := []*database.Chirp{&thread.Chirp}
+	for i := range thread.Replies {
+		refs = append(refs, threadRefs(&thread.Replies[i])...)
+	}
+	return refs
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/natac13/go-chirpy/internal/auth"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+type ReportRequest struct {
+	// Reason is one of database.ReportReasons
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+const maxReportDetailsLength = 500
+
+// HandleReportChirp reports a chirp for moderation
+func HandleReportChirp(db database.Store) http.HandlerFunc {
+	return handleReport(db, func(reporterId, id int) (database.NewReport, int, string) {
+		chirp, err := db.GetChirpById(id)
+		if err != nil || chirp.Id == 0 || chirp.Removed() || hiddenFrom(chirp, reporterId) {
+			return database.NewReport{}, http.StatusNotFound, "Chirp not found"
+		}
+		if chirp.AuthorId == reporterId {
+			return database.NewReport{}, http.StatusBadRequest, "You can't report your own chirp"
+		}
+
+		return database.NewReport{ChirpId: id}, 0, ""
+	})
+}
+
+// HandleReportUser reports a user for moderation
+func HandleReportUser(db database.Store) http.HandlerFunc {
+	return handleReport(db, func(reporterId, id int) (database.NewReport, int, string) {
+		if id == reporterId {
+			return database.NewReport{}, http.StatusBadRequest, "You can't report yourself"
+		}
+		if _, err := db.GetUserById(id); err != nil {
+			return database.NewReport{}, http.StatusNotFound, "User not found"
+		}
+
+		return database.NewReport{UserId: id}, 0, ""
+	})
+}
+
+// handleReport files a report about the chirp or user in the path, target checks
+// it can be reported and says what the report is about or why it can't be filed.
+// Reporting the same thing again while the report is open returns that report.
+func handleReport(db database.Store, target func(reporterId, id int) (database.NewReport, int, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		var req ReportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		if !slices.Contains(database.ReportReasons, req.Reason) {
+			response.RespondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Invalid reason, must be one of %s", strings.Join(database.ReportReasons, ", ")))
+			return
+		}
+
+		if len(req.Details) > maxReportDetailsLength {
+			response.RespondWithError(w, http.StatusBadRequest, "Report details are too long")
+			return
+		}
+
+		newReport, status, msg := target(userId, id)
+		if status != 0 {
+			response.RespondWithError(w, status, msg)
+			return
+		}
+
+		newReport.ReporterId = userId
+		newReport.Reason = req.Reason
+		newReport.Details = req.Details
+		report, err := db.CreateReport(newReport)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusCreated, report)
+	}
+}
+
+// HandleGetReports lists reports for admins, oldest first. status selects open
+// (the default), resolved, dismissed or all reports, chirp_id and user_id
+// narrow them to the reports about a chirp or user.
+func HandleGetReports(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := database.ReportQuery{Status: r.URL.Query().Get("status")}
+		switch query.Status {
+		case "":
+			query.Status = database.ReportOpen
+		case "all":
+			query.Status = ""
+		case database.ReportOpen, database.ReportResolved, database.ReportDismissed:
+		default:
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid status")
+			return
+		}
+
+		query.ChirpId, _ = strconv.Atoi(r.URL.Query().Get("chirp_id"))
+		query.UserId, _ = strconv.Atoi(r.URL.Query().Get("user_id"))
+
+		reports, err := db.GetReports(query)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, reports)
+	}
+}
+
+// HandleCloseReport resolves or dismisses an open report for admins,
+// with an optional note in the body
+func HandleCloseReport(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid report id")
+			return
+		}
+
+		var req struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		report, err := db.CloseReport(id, r.PathValue("decision"), req.Note)
+		if errors.Is(err, database.ErrUnknownDecision) {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrReportNotFound) {
+			response.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrReportClosed) {
+			response.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, report)
+	}
+}
+
+// HandleGetFlaggedUsers lists the users flagged by reports for admins
+func HandleGetFlaggedUsers(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := db.GetFlaggedUsers()
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		res := make([]UserResponse, 0, len(users))
+		for _, user := range users {
+			res = append(res, newUserResponse(user))
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/natac13/go-chirpy/internal/auth"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/moderation"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+// ScheduledChirpRequest edits a scheduled chirp, fields left out are kept
+type ScheduledChirpRequest struct {
+	Body      *string    `json:"body"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+var errPublishAtPast = errors.New("publish_at must be in the future")
+
+// scheduledForAuthor leaves out why moderation flagged a scheduled chirp,
+// only moderators see that once it is published
+func scheduledForAuthor(scheduled database.ScheduledChirp) database.ScheduledChirp {
+	scheduled.FlagReasons = nil
+	return scheduled
+}
+
+// HandleGetScheduledChirps lists the requesting user's chirps waiting
+// to be published, the next to be published first
+func HandleGetScheduledChirps(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		chirps, err := db.GetScheduledChirps(userId)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for i := range chirps {
+			chirps[i] = scheduledForAuthor(chirps[i])
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, chirps)
+	}
+}
+
+// HandleUpdateScheduledChirp changes the body or publish time of one
+// of the requesting user's scheduled chirps
+func HandleUpdateScheduledChirp(db database.Store, moderator *moderation.Moderator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		scheduled, ok := authorScheduledChirp(db, w, r, userId)
+		if !ok {
+			return
+		}
+
+		var req ScheduledChirpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		publishAt := scheduled.PublishAt
+		if req.PublishAt != nil {
+			if !req.PublishAt.After(time.Now()) {
+				response.RespondWithError(w, http.StatusBadRequest, errPublishAtPast.Error())
+				return
+			}
+			publishAt = *req.PublishAt
+		}
+
+		body, reasons := scheduled.Body, scheduled.FlagReasons
+		if req.Body != nil {
+			if scheduled.RechirpOfId != 0 {
+				response.RespondWithError(w, http.StatusBadRequest, "A rechirp can't have a body")
+				return
+			}
+
+			moderated, err := prepareChirpBody(moderator, *req.Body)
+			if err != nil {
+				response.RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			body, reasons = moderated.Body, flagReasons(moderated)
+		}
+
+		scheduled, err = db.UpdateScheduledChirp(scheduled.Id, body, reasons, publishAt)
+		if errors.Is(err, database.ErrScheduledChirpNotFound) {
+			response.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, scheduledForAuthor(scheduled))
+	}
+}
+
+// HandleCancelScheduledChirp deletes one of the requesting user's
+// scheduled chirps before it is published
+func HandleCancelScheduledChirp(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		scheduled, ok := authorScheduledChirp(db, w, r, userId)
+		if !ok {
+			return
+		}
+
+		err = db.CancelScheduledChirp(scheduled.Id)
+		if errors.Is(err, database.ErrScheduledChirpNotFound) {
+			response.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Scheduled chirp cancelled"})
+	}
+}
+
+// authorScheduledChirp looks up the scheduled chirp in the path and checks it
+// belongs to userId, responding with an error if it doesn't
+func authorScheduledChirp(db database.Store, w http.ResponseWriter, r *http.Request, userId int) (database.ScheduledChirp, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, "Invalid scheduled chirp id")
+		return database.ScheduledChirp{}, false
+	}
+
+	scheduled, err := db.GetScheduledChirpById(id)
+	if err != nil {
+		response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return database.ScheduledChirp{}, false
+	}
+
+	if scheduled.Id == 0 {
+		response.RespondWithError(w, http.StatusNotFound, database.ErrScheduledChirpNotFound.Error())
+		return database.ScheduledChirp{}, false
+	}
+
+	if scheduled.AuthorId != userId {
+		response.RespondWithError(w, http.StatusForbidden, "You are not the author of this chirp")
+		return database.ScheduledChirp{}, false
+	}
+
+	return scheduled, true
+}
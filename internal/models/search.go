@@ -0,0 +1,62 @@
+package models
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+	"github.com/natac13/go-chirpy/internal/search"
+)
+
+// HandleSearchChirps returns the chirps containing every word of q, best
+// match first, optionally narrowed by author_id, since and until
+func HandleSearchChirps(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		terms := search.Terms(search.Tokenize(r.URL.Query().Get("q")))
+		if len(terms) == 0 {
+			response.RespondWithError(w, http.StatusBadRequest, "Search query has no words to search for")
+			return
+		}
+
+		authorId, err := strconv.Atoi(r.URL.Query().Get("author_id"))
+		if err != nil {
+			authorId = 0
+		}
+
+		// the cursor of a search page is the offset of the next one
+		filter := database.ChirpQuery{AuthorId: authorId, ViewerId: viewerId(r)}
+		if err := parseTimeRange(r, &filter); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := parsePage(r, &filter); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		page, err := db.SearchChirps(database.SearchQuery{
+			Terms:  terms,
+			Filter: filter,
+			Offset: filter.After,
+			Limit:  filter.Limit,
+		})
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := decorateChirps(db, r, chirpRefs(page.Chirps)); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		res := ChirpPageResponse{Chirps: page.Chirps}
+		if page.Next != 0 {
+			res.NextCursor = encodeCursor(page.Next)
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/natac13/go-chirpy/internal/auth"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+// HandleGetTrashedChirps lists the requesting user's deleted chirps
+// that can still be restored, most recently deleted first
+func HandleGetTrashedChirps(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		chirps, err := db.GetTrashedChirps(userId)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := decorateChirps(db, r, chirpRefs(chirps)); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, chirps)
+	}
+}
+
+// HandleRestoreChirp takes one of the requesting user's chirps out of the
+// trash, as long as it was deleted less than retention ago
+func HandleRestoreChirp(db database.Store, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := auth.ValidateToken(r)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
+		chirp, err := db.GetChirpById(id)
+		if err != nil || chirp.Id == 0 {
+			response.RespondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+
+		if chirp.AuthorId != userId {
+			response.RespondWithError(w, http.StatusForbidden, "You are not the author of this chirp")
+			return
+		}
+
+		chirp, err = db.RestoreChirp(id, time.Now().UTC().Add(-retention))
+		if errors.Is(err, database.ErrNotInTrash) {
+			response.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrRestoreExpired) {
+			response.RespondWithError(w, http.StatusGone, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := decorateChirps(db, r, []*database.Chirp{&chirp}); err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, chirp)
+	}
+}
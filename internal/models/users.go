@@ -2,7 +2,10 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/natac13/go-chirpy/internal/auth"
 	"github.com/natac13/go-chirpy/internal/database"
@@ -12,23 +15,68 @@ import (
 type UserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Username string `json:"username"`
 }
 
+var errInvalidUsername = errors.New("Usernames are 1 to 15 letters, digits or underscores")
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
 type UserResponse struct {
-	Email        string `json:"email"`
-	Id           int    `json:"id"`
-	Password     string `json:"-"`
-	Token        string `json:"token,omitempty"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Email          string    `json:"email"`
+	Id             int       `json:"id"`
+	Password       string    `json:"-"`
+	Token          string    `json:"token,omitempty"`
+	RefreshToken   string    `json:"refresh_token,omitempty"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	FollowerCount  int       `json:"follower_count"`
+	FollowingCount int       `json:"following_count"`
+	Username       string    `json:"username,omitempty"`
+}
+
+// PublicUserResponse is a user as seen by everyone else, without their email
+type PublicUserResponse struct {
+	Id             int       `json:"id"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	FollowerCount  int       `json:"follower_count"`
+	FollowingCount int       `json:"following_count"`
+	Username       string    `json:"username,omitempty"`
+}
+
+// newUserResponse is the view of a user for the account owner, without tokens
+func newUserResponse(user database.User) UserResponse {
+	return UserResponse{
+		Email:          user.Email,
+		Id:             user.Id,
+		IsChirpyRed:    user.IsChirpyRed,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+		FollowerCount:  user.FollowerCount,
+		FollowingCount: user.FollowingCount,
+		Username:       user.Username,
+	}
 }
 
-func HandleCreateUser(db *database.DB) http.HandlerFunc {
+func newPublicUserResponse(user database.User) PublicUserResponse {
+	return PublicUserResponse{
+		Id:             user.Id,
+		IsChirpyRed:    user.IsChirpyRed,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+		FollowerCount:  user.FollowerCount,
+		FollowingCount: user.FollowingCount,
+		Username:       user.Username,
+	}
+}
+
+func HandleCreateUser(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		var userRequest UserRequest
@@ -38,21 +86,26 @@ func HandleCreateUser(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		user, err := db.CreateUser(userRequest.Email, userRequest.Password)
+		if userRequest.Username != "" && !database.ValidUsername(userRequest.Username) {
+			response.RespondWithError(w, http.StatusBadRequest, errInvalidUsername.Error())
+			return
+		}
+
+		user, err := db.CreateUser(userRequest.Email, userRequest.Password, userRequest.Username)
+		if errors.Is(err, database.ErrUsernameTaken) {
+			response.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		if err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		response.RespondWithJSON(w, http.StatusCreated, UserResponse{
-			Email:       user.Email,
-			Id:          user.Id,
-			IsChirpyRed: user.IsChirpyRed,
-		})
+		response.RespondWithJSON(w, http.StatusCreated, newUserResponse(user))
 	}
 }
 
-func HandleUserLogin(db *database.DB) http.HandlerFunc {
+func HandleUserLogin(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		var loginRequest LoginRequest
@@ -80,22 +133,20 @@ func HandleUserLogin(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		response.RespondWithJSON(w, http.StatusOK, UserResponse{
-			Email:        user.Email,
-			Id:           user.Id,
-			Token:        accessToken,
-			RefreshToken: refreshToken,
-			IsChirpyRed:  user.IsChirpyRed,
-		})
+		res := newUserResponse(user)
+		res.Token = accessToken
+		res.RefreshToken = refreshToken
+		response.RespondWithJSON(w, http.StatusOK, res)
 	}
 }
 
 type UserUpdateRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Username string `json:"username"`
 }
 
-func HandleUpdateUser(db *database.DB) http.HandlerFunc {
+func HandleUpdateUser(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userId, err := auth.ValidateToken(r)
 		if err != nil {
@@ -111,18 +162,41 @@ func HandleUpdateUser(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		user, err := db.UpdateUser(userId, userUpdateRequest.Email, userUpdateRequest.Password)
+		if userUpdateRequest.Username != "" && !database.ValidUsername(userUpdateRequest.Username) {
+			response.RespondWithError(w, http.StatusBadRequest, errInvalidUsername.Error())
+			return
+		}
 
+		user, err := db.UpdateUser(userId, userUpdateRequest.Email, userUpdateRequest.Password, userUpdateRequest.Username)
+		if errors.Is(err, database.ErrUsernameTaken) || errors.Is(err, database.ErrEmailTaken) {
+			response.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		if err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		response.RespondWithJSON(w, http.StatusOK, UserResponse{
-			Email:       user.Email,
-			Id:          user.Id,
-			IsChirpyRed: user.IsChirpyRed,
-		})
+		response.RespondWithJSON(w, http.StatusOK, newUserResponse(user))
+
+	}
+}
+
+func HandleGetUser(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid user id")
+			return
+		}
+
+		user, err := db.GetUserById(id)
+		if err != nil {
+			response.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
 
+		response.RespondWithJSON(w, http.StatusOK, newPublicUserResponse(user))
 	}
 }
@@ -0,0 +1,99 @@
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+const (
+	FilterWords = "words"
+	FilterRegex = "regex"
+	FilterLinks = "links"
+)
+
+// Config is the moderation config file, its filters run in order
+type Config struct {
+	Filters []FilterConfig `json:"filters"`
+}
+
+// FilterConfig configures one filter of the chain
+type FilterConfig struct {
+	// Type is words, regex or links
+	Type string `json:"type"`
+	// Name identifies the filter in moderation reasons, defaults to Type
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+	// Words are matched as whole words ignoring case, punctuation,
+	// accents and look-alike characters, for words filters
+	Words []string `json:"words"`
+	// Patterns are regular expressions, for regex filters
+	Patterns []string `json:"patterns"`
+	// Allow lists the domains links may point to, subdomains included,
+	// for links filters. Every other link matches.
+	Allow []string `json:"allow"`
+}
+
+// DefaultConfig masks the words chirpy has always masked
+func DefaultConfig() Config {
+	return Config{Filters: []FilterConfig{
+		{Type: FilterWords, Name: "profanity", Action: ActionMask, Words: []string{"kerfuffle", "sharbert", "fornax"}},
+	}}
+}
+
+// LoadConfig reads a json config file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("reading moderation config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// NewChain builds the filters of cfg
+func NewChain(cfg Config) (*Chain, error) {
+	chain := &Chain{}
+	for i, fc := range cfg.Filters {
+		name := fc.Name
+		if name == "" {
+			name = fc.Type
+		}
+
+		switch fc.Action {
+		case ActionMask, ActionReject, ActionFlag:
+		default:
+			return nil, fmt.Errorf("moderation filter %d (%s): unknown action %q", i, name, fc.Action)
+		}
+
+		var filter Filter
+		switch fc.Type {
+		case FilterWords:
+			filter = newWordFilter(fc.Words)
+		case FilterRegex:
+			patterns := []*regexp.Regexp{}
+			for _, p := range fc.Patterns {
+				re, err := regexp.Compile(p)
+				if err != nil {
+					return nil, fmt.Errorf("moderation filter %d (%s): %w", i, name, err)
+				}
+				patterns = append(patterns, re)
+			}
+			filter = regexFilter{patterns: patterns}
+		case FilterLinks:
+			filter = newLinkFilter(fc.Allow)
+		default:
+			return nil, fmt.Errorf("moderation filter %d (%s): unknown type %q", i, name, fc.Type)
+		}
+
+		chain.rules = append(chain.rules, rule{name: name, action: fc.Action, filter: filter})
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,188 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wordFilter matches whole words from a list after normalizing them,
+// so "Kerfuffle!", "k3rfuffl3" and "kérfüffle" all match "kerfuffle"
+type wordFilter struct {
+	words map[string]bool
+}
+
+func newWordFilter(words []string) wordFilter {
+	f := wordFilter{words: map[string]bool{}}
+	for _, word := range words {
+		if word = normalizeWord(word); word != "" {
+			f.words[word] = true
+		}
+	}
+
+	return f
+}
+
+func (f wordFilter) Find(text string) []Span {
+	spans := []Span{}
+	start := -1
+	for i, r := range text + " " {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+
+		word := text[start:i]
+		if f.words[normalizeWord(word)] || strings.HasPrefix(word, "@") && f.words[normalizeWord(word[1:])] {
+			spans = append(spans, Span{Start: start, End: i})
+		}
+		start = -1
+	}
+
+	return spans
+}
+
+// isWordRune reports whether r can be part of a word, including the symbols
+// that stand in for letters and the invisible characters used to split words up
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Cf, r) || lookAlikes[r] != 0
+}
+
+// lookAlikes maps characters that are commonly used in place of a letter to that letter
+var lookAlikes = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's',
+	// cyrillic and greek letters that look latin
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ο': 'o', 'α': 'a', 'ν': 'v',
+}
+
+// accents maps accented latin letters to the letter without the accent
+var accents = map[rune]rune{}
+
+func init() {
+	for base, accented := range map[rune]string{
+		'a': "àáâãäåāăą", 'c': "çćĉċč", 'e': "èéêëēĕėęě", 'i': "ìíîïĩīĭįı",
+		'n': "ñńņňŉ", 'o': "òóôõöøōŏő", 'u': "ùúûüũūŭůűų", 'y': "ýÿŷ",
+		's': "śŝşš", 'z': "źżž", 'g': "ĝğġģ", 'l': "ĺļľŀł", 'r': "ŕŗř", 'd': "ďđ", 't': "ţťŧ",
+	} {
+		for _, r := range accented {
+			accents[r] = base
+		}
+	}
+}
+
+// normalizeWord lowercases a word, folds full-width letters, accents and
+// look-alikes to plain latin letters and drops invisible characters
+func normalizeWord(word string) string {
+	var sb strings.Builder
+	for _, r := range word {
+		// full-width forms of ascii characters
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFF01 - 0x21
+		}
+
+		r = unicode.ToLower(r)
+		switch {
+		case unicode.Is(unicode.Cf, r):
+			continue
+		case accents[r] != 0:
+			r = accents[r]
+		case lookAlikes[r] != 0:
+			r = lookAlikes[r]
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// regexFilter matches regular expressions against the body as written
+type regexFilter struct {
+	patterns []*regexp.Regexp
+}
+
+func (f regexFilter) Find(text string) []Span {
+	spans := []Span{}
+	for _, re := range f.patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			spans = append(spans, Span{Start: loc[0], End: loc[1]})
+		}
+	}
+
+	return spans
+}
+
+// linkPattern finds links with a scheme, starting with www. or made of a
+// domain with a common top level domain, capturing the host
+var linkPattern = regexp.MustCompile(`(?i)(?:\b[a-z][a-z0-9+.-]*://|\bwww\.)([^\s/?#]+)[^\s]*|\b((?:[a-z0-9-]+\.)+(?:com|net|org|io|co|dev|app|xyz|info|biz|me|ly|gg|tv|us|uk|ru|de))\b[^\s]*`)
+
+// linkFilter matches links to domains that aren't allowed
+type linkFilter struct {
+	allow []string
+}
+
+func newLinkFilter(allow []string) linkFilter {
+	f := linkFilter{}
+	for _, domain := range allow {
+		f.allow = append(f.allow, strings.ToLower(strings.TrimPrefix(domain, ".")))
+	}
+
+	return f
+}
+
+func (f linkFilter) Find(text string) []Span {
+	spans := []Span{}
+	for _, loc := range linkPattern.FindAllStringSubmatchIndex(text, -1) {
+		// the domain of an email address isn't a link
+		if loc[0] > 0 && text[loc[0]-1] == '@' {
+			continue
+		}
+
+		host := ""
+		if loc[2] >= 0 {
+			host = text[loc[2]:loc[3]]
+		} else {
+			host = text[loc[4]:loc[5]]
+		}
+
+		if !f.allowed(host) {
+			end := loc[1]
+			// a link at the end of a sentence doesn't include the full stop
+			for end > loc[0] {
+				r, size := utf8.DecodeLastRuneInString(text[:end])
+				if !strings.ContainsRune(".,;:!?)'\"", r) {
+					break
+				}
+				end -= size
+			}
+			spans = append(spans, Span{Start: loc[0], End: end})
+		}
+	}
+
+	return spans
+}
+
+// allowed reports whether host is an allowed domain or a subdomain of one
+func (f linkFilter) allowed(host string) bool {
+	host = strings.ToLower(strings.TrimRight(host, ".,;:!?)'\""))
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.TrimPrefix(host, "www.")
+
+	for _, domain := range f.allow {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
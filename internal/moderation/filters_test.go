@@ -0,0 +1,166 @@
+package moderation
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestWordFilter(t *testing.T) {
+	f := newWordFilter([]string{"kerfuffle", "Sharbert"})
+
+	tests := []struct {
+		name string
+		text string
+		want []Span
+	}{
+		{"no match", "what a lovely day", []Span{}},
+		{"plain word", "a kerfuffle here", []Span{{2, 11}}},
+		{"case and punctuation", "Kerfuffle! SHARBERT.", []Span{{0, 9}, {11, 19}}},
+		{"look-alikes", "k3rfuffl3", []Span{{0, 9}}},
+		{"accents", "kérfüffle", []Span{{0, 11}}},
+		{"invisible characters", "ker\u200bfuffle", []Span{{0, 12}}},
+		{"mention", "hi @sharbert", []Span{{3, 12}}},
+		{"part of a longer word", "kerfuffles", []Span{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Find(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexFilter(t *testing.T) {
+	f := regexFilter{patterns: []*regexp.Regexp{
+		regexp.MustCompile(`\d{3}-\d{4}`),
+		regexp.MustCompile(`(?i)buy now`),
+	}}
+
+	tests := []struct {
+		name string
+		text string
+		want []Span
+	}{
+		{"no match", "call me", []Span{}},
+		{"one pattern", "call 555-1234", []Span{{5, 13}}},
+		{"every match", "555-1234 or 555-4321", []Span{{0, 8}, {12, 20}}},
+		{"several patterns", "BUY NOW 555-1234", []Span{{8, 16}, {0, 7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Find(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	f := newLinkFilter([]string{"example.com", ".chirpy.dev"})
+
+	tests := []struct {
+		name string
+		text string
+		want []Span
+	}{
+		{"no links", "nothing to see", []Span{}},
+		{"scheme", "see https://spam.net/x", []Span{{4, 22}}},
+		{"www", "see www.spam.net", []Span{{4, 16}}},
+		{"bare domain", "see spam.net today", []Span{{4, 12}}},
+		{"trailing full stop", "go to spam.net.", []Span{{6, 14}}},
+		{"allowed domain", "see https://example.com/a", []Span{}},
+		{"allowed subdomain", "see docs.chirpy.dev", []Span{}},
+		{"allowed with port", "see http://www.example.com:8080/", []Span{}},
+		{"lookalike of an allowed domain", "see notexample.com", []Span{{4, 18}}},
+		{"email address", "mail me at me@spam.net", []Span{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Find(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainModerate(t *testing.T) {
+	chain, err := NewChain(Config{Filters: []FilterConfig{
+		{Type: FilterWords, Name: "profanity", Action: ActionMask, Words: []string{"kerfuffle"}},
+		{Type: FilterRegex, Name: "phone", Action: ActionFlag, Patterns: []string{`\d{3}-\d{4}`}},
+		{Type: FilterLinks, Action: ActionReject, Allow: []string{"example.com"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want Result
+	}{
+		{"clean", "hello there", Result{Body: "hello there"}},
+		{"masked", "what a Kerfuffle!", Result{Body: "what a ****!"}},
+		{"flagged", "call 555-1234", Result{Body: "call 555-1234", Flagged: true, Reasons: []string{"phone"}}},
+		{"rejected", "see spam.net", Result{Body: "see spam.net", Rejected: true, Reasons: []string{"links"}}},
+		{
+			"every filter",
+			"kerfuffle 555-1234 spam.net",
+			Result{Body: "**** 555-1234 spam.net", Rejected: true, Flagged: true, Reasons: []string{"phone", "links"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chain.Moderate(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Moderate(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewChainErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter FilterConfig
+	}{
+		{"unknown action", FilterConfig{Type: FilterWords, Action: "delete"}},
+		{"unknown type", FilterConfig{Type: "images", Action: ActionMask}},
+		{"bad pattern", FilterConfig{Type: FilterRegex, Action: ActionMask, Patterns: []string{"("}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewChain(Config{Filters: []FilterConfig{tt.filter}}); err == nil {
+				t.Error("NewChain succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		spans []Span
+		want  string
+	}{
+		{"no spans", "abc", nil, "abc"},
+		{"one span", "abc def", []Span{{4, 7}}, "abc ****"},
+		{"unsorted spans", "ab cd ef", []Span{{6, 8}, {0, 2}}, "**** cd ****"},
+		{"overlapping spans", "abcdef", []Span{{0, 4}, {2, 6}}, "****"},
+		{"nested spans", "abcdef", []Span{{0, 6}, {2, 3}}, "****"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mask(tt.text, tt.spans); got != tt.want {
+				t.Errorf("mask(%q, %v) = %q, want %q", tt.text, tt.spans, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package moderation
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Action is what happens to a chirp when a filter matches it
+type Action string
+
+const (
+	// ActionMask replaces the matched text with maskReplacement
+	ActionMask Action = "mask"
+	// ActionReject refuses the chirp
+	ActionReject Action = "reject"
+	// ActionFlag lets the chirp through but flags it for review
+	ActionFlag Action = "flag"
+)
+
+const maskReplacement = "****"
+
+// Span is a byte range [Start, End) of the text a filter objects to
+type Span struct {
+	Start int
+	End   int
+}
+
+// Filter finds the parts of a chirp body it objects to
+type Filter interface {
+	Find(text string) []Span
+}
+
+// rule is a filter in a chain along with what to do when it matches
+type rule struct {
+	name   string
+	action Action
+	filter Filter
+}
+
+// Chain runs a chirp body through every filter in order
+type Chain struct {
+	rules []rule
+}
+
+// Result is the outcome of moderating a chirp body
+type Result struct {
+	// Body is the body with the text matched by masking filters replaced
+	Body     string
+	Rejected bool
+	Flagged  bool
+	// Reasons names the filters that rejected or flagged the body
+	Reasons []string
+}
+
+// Moderate runs body through the chain. Every filter sees the original
+// body, so masking by one filter doesn't hide text from the next.
+func (c *Chain) Moderate(body string) Result {
+	result := Result{Body: body}
+	masked := []Span{}
+	for _, rule := range c.rules {
+		spans := rule.filter.Find(body)
+		if len(spans) == 0 {
+			continue
+		}
+
+		switch rule.action {
+		case ActionMask:
+			masked = append(masked, spans...)
+		case ActionReject:
+			result.Rejected = true
+			result.Reasons = append(result.Reasons, rule.name)
+		case ActionFlag:
+			result.Flagged = true
+			result.Reasons = append(result.Reasons, rule.name)
+		}
+	}
+
+	result.Body = mask(body, masked)
+	return result
+}
+
+// mask replaces the spans of text with maskReplacement, overlapping spans are masked once
+func mask(text string, spans []Span) string {
+	if len(spans) == 0 {
+		return text
+	}
+
+	slices.SortFunc(spans, func(a, b Span) int {
+		return a.Start - b.Start
+	})
+
+	var sb strings.Builder
+	pos := 0
+	for _, span := range spans {
+		if span.End <= pos {
+			continue
+		}
+		if span.Start >= pos {
+			sb.WriteString(text[pos:span.Start])
+			sb.WriteString(maskReplacement)
+		}
+		pos = span.End
+	}
+	sb.WriteString(text[pos:])
+
+	return sb.String()
+}
+
+// Moderator moderates chirps with the chain built from a config file,
+// Reload swaps in a new chain without interrupting chirps being moderated
+type Moderator struct {
+	path  string
+	chain atomic.Pointer[Chain]
+}
+
+// NewModerator builds the chain from the config at path,
+// an empty path uses DefaultConfig
+func NewModerator(path string) (*Moderator, error) {
+	m := &Moderator{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Reload rereads the config file, keeping the current chain if it is invalid
+func (m *Moderator) Reload() error {
+	cfg := DefaultConfig()
+	if m.path != "" {
+		var err error
+		cfg, err = LoadConfig(m.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	chain, err := NewChain(cfg)
+	if err != nil {
+		return err
+	}
+
+	m.chain.Store(chain)
+	slog.Info("MODERATION - Loaded filters", "path", m.path, "filters", len(chain.rules))
+	return nil
+}
+
+// Moderate runs body through the current chain
+func (m *Moderator) Moderate(body string) Result {
+	return m.chain.Load().Moderate(body)
+}
@@ -0,0 +1,95 @@
+package search
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, k1 limits how much repeating a term counts and
+// b how much longer chirps are penalized
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// stopWords are too common to be worth indexing or searching for
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "for": true, "if": true, "in": true, "into": true, "is": true,
+	"it": true, "no": true, "not": true, "of": true, "on": true, "or": true, "such": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true, "these": true,
+	"they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// Tokenize splits text into lowercased words of letters and digits,
+// dropping stop words. A word can appear more than once.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := []string{}
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if !stopWords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
+
+// Terms returns the distinct tokens, in the order they first appear
+func Terms(tokens []string) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		if !seen[token] {
+			seen[token] = true
+			terms = append(terms, token)
+		}
+	}
+
+	return terms
+}
+
+// Stats describe the indexed chirps for ranking
+type Stats struct {
+	// Docs is the number of chirps with at least one token
+	// and Tokens the number of tokens across all of them
+	Docs   int
+	Tokens int
+	// DocFreq is the number of chirps containing each query term
+	DocFreq map[string]int
+}
+
+// Score ranks a chirp with the given tokens against the query terms using BM25,
+// a higher score is a better match
+func Score(stats Stats, terms []string, tokens []string) float64 {
+	if stats.Docs == 0 {
+		return 0
+	}
+
+	freq := map[string]int{}
+	for _, token := range tokens {
+		freq[token]++
+	}
+
+	avgLen := float64(stats.Tokens) / float64(stats.Docs)
+	length := float64(len(tokens)) / avgLen
+
+	score := 0.0
+	for _, term := range terms {
+		tf := float64(freq[term])
+		if tf == 0 {
+			continue
+		}
+
+		df := float64(stats.DocFreq[term])
+		idf := math.Log(1 + (float64(stats.Docs)-df+0.5)/(df+0.5))
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*length))
+	}
+
+	return score
+}
@@ -0,0 +1,65 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"lowercased", "Hello World", []string{"hello", "world"}},
+		{"punctuation splits words", "go-chirpy, v2!", []string{"go", "chirpy", "v2"}},
+		{"stop words dropped", "the cat and the hat", []string{"cat", "hat"}},
+		{"repeats kept", "go go", []string{"go", "go"}},
+		{"unicode letters", "Café crème", []string{"café", "crème"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerms(t *testing.T) {
+	got := Terms([]string{"b", "a", "b", "c", "a"})
+	if want := []string{"b", "a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("Terms = %q, want %q", got, want)
+	}
+}
+
+func TestScore(t *testing.T) {
+	stats := Stats{Docs: 4, Tokens: 12, DocFreq: map[string]int{"go": 2, "rare": 1}}
+
+	tests := []struct {
+		name   string
+		terms  []string
+		better []string
+		worse  []string
+	}{
+		{"repeated term", []string{"go"}, []string{"go", "go", "x"}, []string{"go", "x", "y"}},
+		{"shorter chirp", []string{"go"}, []string{"go", "x"}, []string{"go", "x", "y", "z"}},
+		{"rarer term", []string{"go", "rare"}, []string{"rare"}, []string{"go"}},
+		{"any match", []string{"go"}, []string{"go"}, []string{"x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			better, worse := Score(stats, tt.terms, tt.better), Score(stats, tt.terms, tt.worse)
+			if better <= worse {
+				t.Errorf("score %v for %q isn't above %v for %q", better, tt.better, worse, tt.worse)
+			}
+		})
+	}
+
+	if got := Score(Stats{}, []string{"go"}, []string{"go"}); got != 0 {
+		t.Errorf("score without indexed chirps = %v, want 0", got)
+	}
+}
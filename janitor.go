@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	tokenJanitorInterval = time.Hour
+	trashJanitorInterval = time.Hour
+)
+
+// runJanitor calls purge on start and then every interval
+// for the lifetime of the process
+func runJanitor(name string, interval time.Duration, purge func(now time.Time) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := purge(time.Now().UTC())
+		if err != nil {
+			slog.Error("Error running janitor: ", "janitor", name, "error", err)
+		} else if purged > 0 {
+			slog.Info("Janitor purged expired records", "janitor", name, "purged", purged)
+		}
+
+		<-ticker.C
+	}
+}
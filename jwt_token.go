@@ -8,21 +8,21 @@ import (
 	"github.com/natac13/go-chirpy/internal/response"
 )
 
-func RevokeTokenHandler(db *database.DB) http.HandlerFunc {
+func RevokeTokenHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenWithBearer := r.Header.Get("Authorization")
-		if tokenWithBearer == "" {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
 			response.RespondWithError(w, http.StatusUnauthorized, "No token provided")
 			return
 		}
 
-		token := tokenWithBearer[len("Bearer "):]
-		if token == "" {
-			response.RespondWithError(w, http.StatusUnauthorized, "No token provided")
+		refreshToken, err := auth.ValidateRefreshToken(authHeader)
+		if err != nil {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
-		if err := db.RevokeToken(token); err != nil {
+		if err := db.RevokeToken(refreshToken.Token, refreshToken.ExpiresAt); err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -31,7 +31,7 @@ func RevokeTokenHandler(db *database.DB) http.HandlerFunc {
 	}
 }
 
-func RefreshTokenHandler(db *database.DB) http.HandlerFunc {
+func RefreshTokenHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -39,23 +39,23 @@ func RefreshTokenHandler(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		userId, tokenString, err := auth.ValidateRefreshToken(authHeader)
+		refreshToken, err := auth.ValidateRefreshToken(authHeader)
 		if err != nil {
 			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
-		if db.IsTokenRevoked(tokenString) {
+		if db.IsTokenRevoked(refreshToken.Token) {
 			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
-		if err := db.RevokeToken(tokenString); err != nil {
+		if err := db.RevokeToken(refreshToken.Token, refreshToken.ExpiresAt); err != nil {
 			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		user, err := db.GetUserById(userId)
+		user, err := db.GetUserById(refreshToken.UserId)
 		if err != nil {
 			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
 			return
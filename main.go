@@ -5,26 +5,65 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/natac13/go-chirpy/internal/backup"
 	"github.com/natac13/go-chirpy/internal/database"
 	"github.com/natac13/go-chirpy/internal/models"
+	"github.com/natac13/go-chirpy/internal/moderation"
 )
 
-const (
-	databasePath = "database.json"
-)
+var defaultDatabasePaths = map[string]string{
+	database.DriverJSON:   "database.json",
+	database.DriverSQLite: "database.db",
+}
 
 func main() {
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	driver := flag.String("store", database.DriverJSON, "Storage backend to use (json or sqlite)")
+	databasePath := flag.String("db", "", "Path to the database file (defaults to database.json or database.db)")
+	snapshotDir := flag.String("snapshots", "snapshots", "Directory database snapshots are kept in")
+	snapshotRetain := flag.Int("snapshot-retain", 10, "Number of snapshots to keep, 0 keeps all")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted chirps can be restored from the trash before they are purged")
+	moderationPath := flag.String("moderation", "", "Path to the moderation config, reloaded on SIGHUP (defaults to masking a built-in word list)")
 	flag.Parse()
 
-	if &dbg != nil && *dbg {
-		slog.Info("Debug mode enabled. Deleting database file.", "path", databasePath)
-		os.Remove(databasePath)
+	if *databasePath == "" {
+		*databasePath = defaultDatabasePaths[*driver]
 	}
 	godotenv.Load()
 
+	dbConfig := database.Config{
+		Driver: *driver,
+		Path:   *databasePath,
+	}
+
+	if key := os.Getenv("DATABASE_ENCRYPTION_KEY"); key != "" {
+		encryptionKey, err := database.ParseEncryptionKey(key)
+		if err != nil {
+			slog.Error("Error reading DATABASE_ENCRYPTION_KEY: ", "error", err)
+			os.Exit(1)
+		}
+		dbConfig.EncryptionKey = encryptionKey
+	}
+	snapshots := backup.NewManager(*snapshotDir, *driver, *snapshotRetain)
+
+	if flag.NArg() > 0 {
+		if err := runCommand(dbConfig, snapshots, flag.Args()); err != nil {
+			slog.Error("Error running command: ", "command", flag.Arg(0), "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if &dbg != nil && *dbg {
+		if err := resetDatabase(dbConfig, snapshots); err != nil {
+			slog.Error("Error resetting database: ", "error", err)
+			panic("Error resetting database")
+		}
+	}
+
 	router := http.NewServeMux()
 	config := &apiConfig{
 		fileserverHits: 0,
@@ -32,25 +71,78 @@ func main() {
 
 	staticFiles := http.FileServer(http.Dir("."))
 
-	db, err := database.NewDB(databasePath)
+	db, err := database.Open(dbConfig)
 	if err != nil {
 		slog.Error("Error opening database: ", "error", err)
 		panic("Error opening database")
 	}
+	defer db.Close()
+
+	moderator, err := moderation.NewModerator(*moderationPath)
+	if err != nil {
+		slog.Error("Error loading moderation config: ", "error", err)
+		os.Exit(1)
+	}
+
+	go runJanitor("revoked tokens", tokenJanitorInterval, db.PurgeExpiredTokens)
+	go runJanitor("deleted chirps", trashJanitorInterval, func(now time.Time) (int, error) {
+		return db.PurgeDeletedChirps(now.Add(-*trashRetention))
+	})
+	go runScheduler(chirpSchedulerInterval, db.PublishDueChirps)
+	go reloadModerationOnSignal(moderator)
 
 	router.Handle("/app/*", http.StripPrefix("/app", config.metricsHitMiddleware(staticFiles)))
 	router.HandleFunc("GET /api/healthz", handleHealthz)
 	router.HandleFunc("GET /admin/metrics", handleAdminMetric(config))
 	router.HandleFunc("/api/reset", handleReset(config))
 
-	router.HandleFunc("POST /api/chirps", models.HandleCreateChirp(db))
+	router.HandleFunc("POST /admin/snapshots", middlewareAdmin(handleCreateSnapshot(db, snapshots)))
+	router.HandleFunc("GET /admin/snapshots", middlewareAdmin(handleListSnapshots(snapshots)))
+	router.HandleFunc("POST /admin/snapshots/{name}/restore", middlewareAdmin(handleRestoreSnapshot(db, snapshots)))
+	router.HandleFunc("POST /admin/moderation/reload", middlewareAdmin(handleReloadModeration(moderator)))
+	router.HandleFunc("GET /admin/moderation/queue", middlewareAdmin(handleGetModerationQueue(db)))
+	router.HandleFunc("POST /admin/moderation/queue/{id}/{decision}", middlewareAdmin(handleReviewChirp(db)))
+	router.HandleFunc("GET /admin/reports", middlewareAdmin(models.HandleGetReports(db)))
+	router.HandleFunc("POST /admin/reports/{id}/{decision}", middlewareAdmin(models.HandleCloseReport(db)))
+	router.HandleFunc("GET /admin/moderation/users", middlewareAdmin(models.HandleGetFlaggedUsers(db)))
+
+	router.HandleFunc("POST /api/chirps", models.HandleCreateChirp(db, moderator))
 	router.HandleFunc("GET /api/chirps", models.HandleGetChirps(db))
 	router.HandleFunc("GET /api/chirps/{id}", models.HandleGetChirp(db))
+	router.HandleFunc("PUT /api/chirps/{id}", models.HandleUpdateChirp(db, moderator))
 	router.HandleFunc(("DELETE /api/chirps/{id}"), models.HandleDeleteChirp(db))
+	router.HandleFunc("GET /api/chirps/trash", models.HandleGetTrashedChirps(db))
+	router.HandleFunc("POST /api/chirps/{id}/restore", models.HandleRestoreChirp(db, *trashRetention))
+	router.HandleFunc("GET /api/chirps/scheduled", models.HandleGetScheduledChirps(db))
+	router.HandleFunc("PUT /api/chirps/scheduled/{id}", models.HandleUpdateScheduledChirp(db, moderator))
+	router.HandleFunc("POST /api/chirps/scheduled/{id}/cancel", models.HandleCancelScheduledChirp(db))
+	router.HandleFunc("GET /api/chirps/{id}/revisions", models.HandleGetChirpRevisions(db))
+	router.HandleFunc("GET /api/chirps/{id}/thread", models.HandleGetChirpThread(db))
+	router.HandleFunc("POST /api/chirps/{id}/likes", models.HandleLikeChirp(db))
+	router.HandleFunc("DELETE /api/chirps/{id}/likes", models.HandleUnlikeChirp(db))
+	router.HandleFunc("POST /api/chirps/{id}/report", models.HandleReportChirp(db))
 
 	router.HandleFunc("POST /api/users", models.HandleCreateUser(db))
 	router.HandleFunc("POST /api/login", models.HandleUserLogin(db))
 	router.HandleFunc("PUT /api/users", models.HandleUpdateUser(db))
+	router.HandleFunc("GET /api/users/{id}", models.HandleGetUser(db))
+	router.HandleFunc("GET /api/users/{id}/likes", models.HandleGetUserLikes(db))
+	router.HandleFunc("POST /api/users/{id}/follow", models.HandleFollowUser(db))
+	router.HandleFunc("DELETE /api/users/{id}/follow", models.HandleUnfollowUser(db))
+	router.HandleFunc("POST /api/users/{id}/report", models.HandleReportUser(db))
+	router.HandleFunc("POST /api/users/{id}/block", models.HandleBlockUser(db))
+	router.HandleFunc("DELETE /api/users/{id}/block", models.HandleUnblockUser(db))
+	router.HandleFunc("POST /api/users/{id}/mute", models.HandleMuteUser(db))
+	router.HandleFunc("DELETE /api/users/{id}/mute", models.HandleUnmuteUser(db))
+	router.HandleFunc("GET /api/blocks", models.HandleGetBlockedUsers(db))
+	router.HandleFunc("GET /api/mutes", models.HandleGetMutedUsers(db))
+	router.HandleFunc("GET /api/users/{id}/followers", models.HandleGetFollowers(db))
+	router.HandleFunc("GET /api/users/{id}/following", models.HandleGetFollowing(db))
+	router.HandleFunc("GET /api/users/{id}/mentions", models.HandleGetUserMentions(db))
+
+	router.HandleFunc("GET /api/timeline", models.HandleGetTimeline(db))
+	router.HandleFunc("GET /api/hashtags/{tag}/chirps", models.HandleGetHashtagChirps(db))
+	router.HandleFunc("GET /api/search", models.HandleSearchChirps(db))
 
 	router.HandleFunc("POST /api/revoke", RevokeTokenHandler(db))
 	router.HandleFunc("POST /api/refresh", RefreshTokenHandler(db))
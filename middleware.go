@@ -1,6 +1,13 @@
 package main
 
-import "net/http"
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/natac13/go-chirpy/internal/response"
+)
 
 func middlewareCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -14,3 +21,28 @@ func middlewareCors(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// middlewareAdmin only lets through requests authorized with the ADMIN_API_KEY
+func middlewareAdmin(next http.HandlerFunc) http.HandlerFunc {
+	apiKey := os.Getenv("ADMIN_API_KEY")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiKey == "" {
+			response.RespondWithError(w, http.StatusForbidden, "Admin API is disabled")
+			return
+		}
+
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			response.RespondWithError(w, http.StatusUnauthorized, "No token provided")
+			return
+		}
+
+		key, ok := strings.CutPrefix(authHeader, "ApiKey ")
+		if !ok || subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) != 1 {
+			response.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/moderation"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+// reloadModerationOnSignal reloads the moderation config whenever the process gets SIGHUP
+func reloadModerationOnSignal(moderator *moderation.Moderator) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if err := moderator.Reload(); err != nil {
+			slog.Error("Error reloading moderation config: ", "error", err)
+		}
+	}
+}
+
+// handleReloadModeration reloads the moderation config, an invalid config
+// is reported and the filters already loaded stay in use
+func handleReloadModeration(moderator *moderation.Moderator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := moderator.Reload(); err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Moderation config reloaded"})
+	}
+}
+
+// handleGetModerationQueue lists the moderation queue, oldest flag first.
+// status selects pending (the default), approved, hidden, deleted or all items.
+func handleGetModerationQueue(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = database.ModerationPending
+		case "all":
+			status = ""
+		case database.ModerationPending, database.ModerationApproved, database.ModerationHidden, database.ModerationDeleted:
+		default:
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid status")
+			return
+		}
+
+		items, err := db.GetModerationQueue(status)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, items)
+	}
+}
+
+// handleReviewChirp records a decision on a chirp in the moderation queue,
+// approve, hide or delete, with an optional note in the body
+func handleReviewChirp(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid chirp id")
+			return
+		}
+
+		var req struct {
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			response.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		item, err := db.ReviewChirp(id, r.PathValue("decision"), req.Note)
+		if errors.Is(err, database.ErrUnknownDecision) {
+			response.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrNotInModerationQueue) {
+			response.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		slog.Info("MODERATION - Chirp reviewed", "chirp_id", id, "status", item.Status)
+		response.RespondWithJSON(w, http.StatusOK, item)
+	}
+}
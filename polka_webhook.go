@@ -19,7 +19,7 @@ type PolkaEventData struct {
 	UserID int `json:"user_id"`
 }
 
-func handlePolkaWebhook(db *database.DB) http.HandlerFunc {
+func handlePolkaWebhook(db database.Store) http.HandlerFunc {
 	apiKey := os.Getenv("POLKA_API_KEY")
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const chirpSchedulerInterval = 10 * time.Second
+
+// runScheduler calls publish on start and then every interval
+// for the lifetime of the process
+func runScheduler(interval time.Duration, publish func(now time.Time) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := publish(time.Now().UTC())
+		if err != nil {
+			slog.Error("Error publishing scheduled chirps: ", "error", err)
+		} else if published > 0 {
+			slog.Info("Scheduler published chirps", "published", published)
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/natac13/go-chirpy/internal/backup"
+	"github.com/natac13/go-chirpy/internal/database"
+	"github.com/natac13/go-chirpy/internal/response"
+)
+
+func handleCreateSnapshot(db database.Store, snapshots *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := snapshots.Create(db)
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusCreated, snapshot)
+	}
+}
+
+func handleListSnapshots(snapshots *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := snapshots.List()
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, list)
+	}
+}
+
+func handleRestoreSnapshot(db database.Store, snapshots *backup.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		err := snapshots.Restore(db, name)
+		if errors.Is(err, backup.ErrSnapshotNotFound) {
+			response.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			response.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Snapshot restored"})
+	}
+}